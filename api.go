@@ -146,12 +146,19 @@ const (
 type IPProtocol uint8
 
 const (
-	IPP_ICMP      IPProtocol = 0x01
-	IPP_TCP       IPProtocol = 0x06
-	IPP_UDP       IPProtocol = 0x11
-	IPP_TLSP      IPProtocol = 0x38
-	IPP_IPV6_ICMP IPProtocol = 0x3A
-	IPP_SCTP      IPProtocol = 0x84
+	IPP_HOPOPT     IPProtocol = 0x00
+	IPP_ICMP       IPProtocol = 0x01
+	IPP_TCP        IPProtocol = 0x06
+	IPP_UDP        IPProtocol = 0x11
+	IPP_IPV6_ROUTE IPProtocol = 0x2B
+	IPP_IPV6_FRAG  IPProtocol = 0x2C
+	IPP_ESP        IPProtocol = 0x32
+	IPP_AH         IPProtocol = 0x33
+	IPP_TLSP       IPProtocol = 0x38
+	IPP_IPV6_ICMP  IPProtocol = 0x3A
+	IPP_IPV6_NONXT IPProtocol = 0x3B
+	IPP_IPV6_OPTS  IPProtocol = 0x3C
+	IPP_SCTP       IPProtocol = 0x84
 )
 
 // PcapFile represents the parsed form of a single .pcap file. The structure
@@ -160,21 +167,32 @@ const (
 type PcapFile struct {
 	MajorVersion uint16
 	MinorVersion uint16
-	TZCorrection int32 // In seconds east of UTC
+	TZCorrection int32 // In seconds, added to local time to get UTC, e.g. -3600 for GMT+1
 	SigFigs      uint32
 	MaxLen       uint32
 	LinkType     Link
+	TSResolution time.Duration // Either time.Microsecond or time.Nanosecond
 	Packets      []Packet
 }
 
 // Packet is a representation of a single network packet. The structure
 // contains the timestamp on the packet, some information about packet size,
-// and the recorded bytes from the packet.
+// and the recorded bytes from the packet. Timestamp is the raw timestamp from
+// the file, as a duration since the epoch. Time is the same moment as an
+// absolute time in UTC, with the file's TZCorrection taken into account: it
+// is Timestamp plus TZCorrection seconds, following the libpcap definition
+// of the thiszone field.
+//
+// The writers use Time in preference to Timestamp whenever Time is set, and
+// packets that have been read always have it set. To change the time of a
+// packet that was read, set Time, or set Timestamp and clear Time with
+// time.Time{}.
 type Packet struct {
 	Timestamp   time.Duration
 	IncludedLen uint32
 	ActualLen   uint32
 	Data        LinkLayer
+	Time        time.Time
 }
 
 // LinkLayer is a non-specific representation of a single link-layer level datagram, e.g. an Ethernet
@@ -205,35 +223,29 @@ type TransportLayer interface {
 // io.Reader interface, but will mostly expect a file produced by anything that
 // produces .pcap files. It will attempt to parse the entire file. If an error
 // is encountered, as much of the parsed content as is possible will be returned,
-// along with an error value.
+// along with an error value. For large files, consider using a Reader instead.
 func Parse(src io.Reader) (PcapFile, error) {
-	file := new(PcapFile)
-
-	// Check whether this is a libpcap file at all, and if so what byte ordering it has.
-	_, flipped, err := checkMagicNum(src)
-	if err != nil {
-		return *file, err
-	}
-
-	// Then populate the file header.
-	err = populateFileHeader(file, src, flipped)
+	r, err := NewReader(src)
+	file := r.header()
 	if err != nil {
-		return *file, err
+		return file, err
 	}
 
 	// Whatever remains now are packets. Parse the rest of the file.
 	file.Packets = make([]Packet, 0)
 
-	for err == nil {
-		pkt := new(Packet)
-		err = parsePacket(pkt, src, flipped, file.LinkType)
-		file.Packets = append(file.Packets, *pkt)
-	}
+	for {
+		pkt, err := r.Next()
 
-	// EOF is a safe error, so switch that to nil.
-	if err == io.EOF {
-		err = nil
-	}
+		// EOF is a safe error: it means we've got all the packets.
+		if err == io.EOF {
+			return file, nil
+		}
 
-	return *file, err
+		file.Packets = append(file.Packets, pkt)
+
+		if err != nil {
+			return file, err
+		}
+	}
 }
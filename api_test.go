@@ -35,8 +35,8 @@ func TestParse(t *testing.T) {
 	if parsed.LinkType != ETHERNET {
 		t.Errorf("Incorrect link type: expected %v, got %v.", ETHERNET, parsed.LinkType)
 	}
-	if len(parsed.Packets) != 2264 {
-		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2264, len(parsed.Packets))
+	if len(parsed.Packets) != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, len(parsed.Packets))
 	}
 
 	// Check the packet header from the first packet. Including the raw data is a lousy way to test, but
@@ -0,0 +1,144 @@
+package gopcap
+
+import (
+	"time"
+)
+
+// PacketBuilder assembles a packet from layers, for building test traffic. Each layer is given as
+// one of the usual layer structs, and the builder stacks them on top of one another with a payload
+// on top. Lengths, IHL, HeaderSize and checksums are filled in automatically when the packet is
+// built, as are the EtherType and IP protocol if they are left as zero, and the TTL or hop limit if
+// it is left as zero. The next header fields of an IPv6 packet with extension headers are left as
+// they are. For example:
+//
+//	data, err := NewPacketBuilder().
+//		Ethernet(&EthernetFrame{MACSource: src, MACDestination: dst}).
+//		IPv4(&IPv4Packet{SourceAddress: srcIP, DestAddress: dstIP}).
+//		UDP(&UDPDatagram{SourcePort: 5353, DestinationPort: 53}).
+//		Payload(query).
+//		Build()
+//
+// Any layer can be left out, in which case the packet starts at the next layer down. The builder
+// links the layers together and fills in their fields, so the structs passed to it are modified,
+// and hold the final values once the packet has been built.
+type PacketBuilder struct {
+	link      LinkLayer
+	internet  InternetLayer
+	transport TransportLayer
+	payload   []byte
+}
+
+// NewPacketBuilder creates an empty PacketBuilder.
+func NewPacketBuilder() *PacketBuilder {
+	return new(PacketBuilder)
+}
+
+// Ethernet sets the link layer to an Ethernet frame.
+func (b *PacketBuilder) Ethernet(frame *EthernetFrame) *PacketBuilder {
+	b.link = frame
+	return b
+}
+
+// IPv4 sets the internet layer to an IPv4 packet, replacing any other internet layer.
+func (b *PacketBuilder) IPv4(pkt *IPv4Packet) *PacketBuilder {
+	b.internet = pkt
+	return b
+}
+
+// IPv6 sets the internet layer to an IPv6 packet, replacing any other internet layer.
+func (b *PacketBuilder) IPv6(pkt *IPv6Packet) *PacketBuilder {
+	b.internet = pkt
+	return b
+}
+
+// TCP sets the transport layer to a TCP segment, replacing any other transport layer.
+func (b *PacketBuilder) TCP(segment *TCPSegment) *PacketBuilder {
+	b.transport = segment
+	return b
+}
+
+// UDP sets the transport layer to a UDP datagram, replacing any other transport layer.
+func (b *PacketBuilder) UDP(dgram *UDPDatagram) *PacketBuilder {
+	b.transport = dgram
+	return b
+}
+
+// Payload sets the data carried by the top layer.
+func (b *PacketBuilder) Payload(data []byte) *PacketBuilder {
+	b.payload = data
+	return b
+}
+
+// Build stacks the layers and returns the bytes of the whole packet.
+func (b *PacketBuilder) Build() ([]byte, error) {
+	top := b.stack()
+	if top == nil {
+		return append([]byte{}, b.payload...), nil
+	}
+
+	return ToBytes(top, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+}
+
+// Write builds the packet and writes it to a pcap file with the given capture time.
+func (b *PacketBuilder) Write(w *Writer, when time.Time) error {
+	data, err := b.Build()
+	if err != nil {
+		return err
+	}
+
+	return w.WritePacket(Packet{Time: when, ActualLen: uint32(len(data))}, data)
+}
+
+// stack links the layers together, filling in the fields that depend on the layer above, and returns
+// the lowest layer.
+func (b *PacketBuilder) stack() interface{} {
+	var transport TransportLayer = &UnknownTransport{data: b.payload}
+	protocol := IPProtocol(0)
+
+	switch t := b.transport.(type) {
+	case *TCPSegment:
+		t.data = b.payload
+		transport, protocol = t, IPP_TCP
+	case *UDPDatagram:
+		t.data = b.payload
+		transport, protocol = t, IPP_UDP
+	}
+
+	var internet InternetLayer = &UnknownINet{data: transport}
+	etherType := EtherType(0)
+
+	switch p := b.internet.(type) {
+	case *IPv4Packet:
+		p.data = transport
+		if p.Protocol == 0 {
+			p.Protocol = protocol
+		}
+		if p.TTL == 0 {
+			p.TTL = 64
+		}
+		internet, etherType = p, ETHERTYPE_IPV4
+	case *IPv6Packet:
+		p.data = transport
+		if p.NextHeader == 0 && len(p.ExtensionHeaders) == 0 {
+			p.NextHeader = protocol
+		}
+		if p.HopLimit == 0 {
+			p.HopLimit = 64
+		}
+		internet, etherType = p, ETHERTYPE_IPV6
+	}
+
+	if frame, ok := b.link.(*EthernetFrame); ok {
+		frame.data = internet
+		if frame.EtherType == 0 && frame.Length == 0 {
+			frame.EtherType = etherType
+		}
+		return frame
+	} else if b.internet != nil {
+		return internet
+	} else if b.transport != nil {
+		return transport
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package gopcap
+
+// ChecksumStatus is the result of verifying a checksum.
+type ChecksumStatus uint8
+
+const (
+	CHECKSUM_UNKNOWN      ChecksumStatus = iota // The checksum can't be checked, e.g. because the layer wasn't decoded from bytes
+	CHECKSUM_VALID                              // The checksum is correct
+	CHECKSUM_INVALID                            // The checksum is wrong
+	CHECKSUM_NOT_COMPUTED                       // The checksum is zero, or was left for the network card to fill in
+	CHECKSUM_TRUNCATED                          // The capture didn't include everything the checksum covers
+)
+
+func (c ChecksumStatus) String() string {
+	switch c {
+	case CHECKSUM_VALID:
+		return "valid"
+	case CHECKSUM_INVALID:
+		return "invalid"
+	case CHECKSUM_NOT_COMPUTED:
+		return "not computed"
+	case CHECKSUM_TRUNCATED:
+		return "truncated"
+	}
+	return "unknown"
+}
+
+// checksumState holds what's needed to verify the checksum of a TCP or UDP packet after it has
+// been decoded: the bytes the checksum covers, and the sum of the pseudo-header from the IP packet
+// that contained it, along with that packet's IP version.
+type checksumState struct {
+	raw       []byte
+	pseudo    uint32
+	hasPseudo bool
+	version   uint8
+	truncated bool
+}
+
+// verify checks a TCP or UDP checksum against the decoded bytes. If optional is set, a zero checksum
+// means the sender didn't compute one. Capture on a host that offloads checksums to its network card
+// leaves only the pseudo-header sum in the checksum field, so that counts as not computed rather
+// than invalid.
+func (c checksumState) verify(checksum uint16, optional bool) ChecksumStatus {
+	if c.truncated {
+		return CHECKSUM_TRUNCATED
+	} else if c.raw == nil || !c.hasPseudo {
+		return CHECKSUM_UNKNOWN
+	} else if optional && checksum == 0 {
+		return CHECKSUM_NOT_COMPUTED
+	} else if foldChecksum(sumBytes(c.raw, c.pseudo)) == 0 {
+		return CHECKSUM_VALID
+	} else if checksum == ^foldChecksum(c.pseudo) {
+		return CHECKSUM_NOT_COMPUTED
+	}
+	return CHECKSUM_INVALID
+}
+
+// setPseudoHeader records the pseudo-header sum and IP version on a decoded TCP or UDP packet, so
+// that its checksum can be verified. Any other kind of transport layer is left alone.
+func setPseudoHeader(layer TransportLayer, pseudo uint32, version uint8) {
+	switch t := layer.(type) {
+	case *TCPSegment:
+		t.sum.pseudo, t.sum.hasPseudo, t.sum.version = pseudo, true, version
+	case *UDPDatagram:
+		t.sum.pseudo, t.sum.hasPseudo, t.sum.version = pseudo, true, version
+	}
+}
+
+// sumBytes adds data, as a series of big-endian 16-bit words, to a running ones' complement sum of
+// the kind used by the Internet checksum (RFC 1071). An odd byte at the end is padded with zero.
+func sumBytes(data []byte, sum uint32) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// foldChecksum folds the carries back into a ones' complement sum and complements it, giving the
+// value of the checksum field.
+func foldChecksum(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+// pseudoHeaderSum returns the sum of the pseudo-header that TCP and UDP checksums cover: the source
+// and destination addresses, the protocol and the length of the transport-layer packet. The IPv4
+// and IPv6 pseudo-headers are laid out differently but have the same sum, so this works for both.
+func pseudoHeaderSum(src []byte, dst []byte, protocol IPProtocol, length int) uint32 {
+	sum := sumBytes(src, 0)
+	sum = sumBytes(dst, sum)
+	sum += uint32(protocol)
+	sum += uint32(length>>16) + uint32(length&0xFFFF)
+	return sum
+}
+
+// setTransportChecksum recomputes the checksum of a serialized TCP or UDP packet, writing it into
+// both the bytes and the layer. pseudo is the sum of the IP pseudo-header. Any other kind of
+// transport layer is left alone.
+func setTransportChecksum(layer TransportLayer, segment []byte, pseudo uint32) {
+	switch t := layer.(type) {
+	case *TCPSegment:
+		if len(segment) < 20 {
+			return
+		}
+		putUint16(segment[16:18], 0, false)
+		t.Checksum = foldChecksum(sumBytes(segment, pseudo))
+		putUint16(segment[16:18], t.Checksum, false)
+	case *UDPDatagram:
+		if len(segment) < 8 {
+			return
+		}
+
+		// A zero checksum means there isn't one, so a computed zero is sent as all ones instead.
+		putUint16(segment[6:8], 0, false)
+		t.Checksum = foldChecksum(sumBytes(segment, pseudo))
+		if t.Checksum == 0 {
+			t.Checksum = 0xFFFF
+		}
+		putUint16(segment[6:8], t.Checksum, false)
+	}
+}
@@ -0,0 +1,150 @@
+package gopcap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	// The worked example from RFC 1071.
+	data := []byte{0x00, 0x01, 0xF2, 0x03, 0xF4, 0xF5, 0xF6, 0xF7}
+	sum := sumBytes(data, 0)
+
+	if sum != 0x2DDF0 {
+		t.Errorf("Unexpected sum: expected %x, got %x.", 0x2DDF0, sum)
+	}
+	if foldChecksum(sum) != 0x220D {
+		t.Errorf("Unexpected checksum: expected %x, got %x.", 0x220D, foldChecksum(sum))
+	}
+
+	// An odd byte is padded with zero.
+	if sumBytes([]byte{0x01, 0x02, 0x03}, 0) != 0x0402 {
+		t.Errorf("Unexpected sum: expected %x, got %x.", 0x0402, sumBytes([]byte{0x01, 0x02, 0x03}, 0))
+	}
+}
+
+// Every checksum in the test file is either valid or was offloaded.
+func TestVerifyChecksumFile(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	counts := make(map[ChecksumStatus]int)
+	r, _ := NewReader(bytes.NewReader(original))
+	for {
+		pkt, err := r.Next()
+		if err != nil {
+			break
+		}
+
+		ip, ok := pkt.Data.LinkData().(*IPv4Packet)
+		if !ok {
+			continue
+		}
+		if ip.VerifyChecksum() != CHECKSUM_VALID {
+			t.Errorf("Unexpected IPv4 checksum status: %v", ip.VerifyChecksum())
+		}
+
+		switch transport := ip.InternetData().(type) {
+		case *TCPSegment:
+			counts[transport.VerifyChecksum()]++
+		case *UDPDatagram:
+			counts[transport.VerifyChecksum()]++
+		}
+	}
+
+	if counts[CHECKSUM_VALID] != 1544 || counts[CHECKSUM_NOT_COMPUTED] != 678 || len(counts) != 2 {
+		t.Errorf("Unexpected checksum statuses: %v", counts)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	// The test frame's TCP checksum was offloaded.
+	frame, _ := parseLinkData(ngTestFrame, ETHERNET)
+	ip := frame.LinkData().(*IPv4Packet)
+	if ip.VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected IPv4 checksum status: expected %v, got %v.", CHECKSUM_VALID, ip.VerifyChecksum())
+	}
+	if ip.InternetData().(*TCPSegment).VerifyChecksum() != CHECKSUM_NOT_COMPUTED {
+		t.Errorf("Unexpected TCP checksum status: expected %v, got %v.", CHECKSUM_NOT_COMPUTED, ip.InternetData().(*TCPSegment).VerifyChecksum())
+	}
+
+	// Once it has been fixed it's valid, and corrupting the payload or the header makes it invalid.
+	fixed, _ := ToBytes(frame, SerializeOptions{ComputeChecksums: true})
+	frame, _ = parseLinkData(fixed, ETHERNET)
+	if frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected TCP checksum status: %v", frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum())
+	}
+
+	fixed[90] ^= 0xFF
+	fixed[22] ^= 0x01
+	frame, _ = parseLinkData(fixed, ETHERNET)
+	if frame.LinkData().(*IPv4Packet).VerifyChecksum() != CHECKSUM_INVALID {
+		t.Errorf("Unexpected IPv4 checksum status: %v", frame.LinkData().(*IPv4Packet).VerifyChecksum())
+	}
+	if frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum() != CHECKSUM_INVALID {
+		t.Errorf("Unexpected TCP checksum status: %v", frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum())
+	}
+
+	// A truncated packet can't be checked.
+	frame, _ = parseLinkData(ngTestFrame[:80], ETHERNET)
+	if frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum() != CHECKSUM_TRUNCATED {
+		t.Errorf("Unexpected TCP checksum status: %v", frame.LinkData().InternetData().(*TCPSegment).VerifyChecksum())
+	}
+
+	// A TCP checksum that comes out as zero is still checked.
+	tcp := &TCPSegment{SourcePort: 44713, DestinationPort: 80, ACK: true}
+	data, _ := NewPacketBuilder().
+		IPv4(&IPv4Packet{SourceAddress: []byte{10, 0, 0, 1}, DestAddress: []byte{10, 0, 0, 2}}).
+		TCP(tcp).
+		Payload([]byte("zero")).
+		Build()
+	ip = new(IPv4Packet)
+	ip.FromBytes(data)
+	if tcp.Checksum != 0 || ip.InternetData().(*TCPSegment).VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected TCP checksum status: %v", ip.InternetData().(*TCPSegment).VerifyChecksum())
+	}
+
+	// Nor can a segment decoded without its IP packet.
+	segment := new(TCPSegment)
+	segment.FromBytes(ngTestFrame[34:])
+	if segment.VerifyChecksum() != CHECKSUM_UNKNOWN {
+		t.Errorf("Unexpected TCP checksum status: expected %v, got %v.", CHECKSUM_UNKNOWN, segment.VerifyChecksum())
+	}
+}
+
+func TestVerifyChecksumUDP(t *testing.T) {
+	data, _ := NewPacketBuilder().
+		IPv6(&IPv6Packet{SourceAddress: make([]byte, 16), DestinationAddress: make([]byte, 16)}).
+		UDP(&UDPDatagram{SourcePort: 53, DestinationPort: 53}).
+		Payload([]byte("response")).
+		Build()
+
+	ip := new(IPv6Packet)
+	ip.FromBytes(data)
+	if ip.InternetData().(*UDPDatagram).VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected UDP checksum status: %v", ip.InternetData().(*UDPDatagram).VerifyChecksum())
+	}
+
+	// Over IPv6 the checksum is mandatory, so a zero one is invalid.
+	data[46], data[47] = 0, 0
+	ip.FromBytes(data)
+	if ip.InternetData().(*UDPDatagram).VerifyChecksum() != CHECKSUM_INVALID {
+		t.Errorf("Unexpected UDP checksum status: %v", ip.InternetData().(*UDPDatagram).VerifyChecksum())
+	}
+
+	// Over IPv4, a zero checksum means there isn't one.
+	data, _ = NewPacketBuilder().
+		IPv4(&IPv4Packet{SourceAddress: []byte{10, 0, 0, 1}, DestAddress: []byte{10, 0, 0, 2}}).
+		UDP(&UDPDatagram{SourcePort: 53, DestinationPort: 53}).
+		Payload([]byte("response")).
+		Build()
+	data[26], data[27] = 0, 0
+	ipv4 := new(IPv4Packet)
+	ipv4.FromBytes(data)
+	if ipv4.InternetData().(*UDPDatagram).VerifyChecksum() != CHECKSUM_NOT_COMPUTED {
+		t.Errorf("Unexpected UDP checksum status: %v", ipv4.InternetData().(*UDPDatagram).VerifyChecksum())
+	}
+}
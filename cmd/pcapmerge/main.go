@@ -0,0 +1,94 @@
+// pcapmerge combines several .pcap or .pcapng files into one, with the packets in timestamp order.
+//
+// Usage:
+//
+//	pcapmerge [-ng] -o merged.pcap first.pcap second.pcapng ...
+//
+// All the input files must have the same link type, unless -ng is given, in which case the output
+// is a pcapng file with one interface per input interface.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Lukasa/gopcap"
+)
+
+func main() {
+	output := flag.String("o", "", "the file to write the merged packets to")
+	ng := flag.Bool("ng", false, "write a pcapng file, allowing inputs with different link types")
+	flag.Parse()
+
+	if *output == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: pcapmerge [-ng] -o <merged> <file.pcap|file.pcapng>...")
+		os.Exit(2)
+	}
+
+	sources := make([]gopcap.MergeSource, 0, flag.NArg())
+	for _, name := range flag.Args() {
+		src, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer src.Close()
+
+		r, err := open(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read %v: %v\n", name, err)
+			os.Exit(1)
+		}
+		sources = append(sources, r)
+	}
+
+	dst, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(dst)
+	if *ng {
+		err = gopcap.MergeNg(out, sources)
+	} else {
+		err = gopcap.Merge(out, sources)
+	}
+
+	// A truncated input still leaves everything before the truncation merged.
+	if err == gopcap.UnexpectedEOF {
+		fmt.Fprintln(os.Stderr, "Warning: an input file ends partway through a packet, which was left out.")
+		err = nil
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = dst.Close()
+	}
+
+	if err == gopcap.MismatchedLinkTypes {
+		fmt.Fprintln(os.Stderr, "The input files have different link types: use -ng to merge them into a pcapng file.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to merge: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// open reads the header of a pcap file, or failing that, a pcapng file.
+func open(src *os.File) (gopcap.MergeSource, error) {
+	r, err := gopcap.NewReader(src)
+	if err != gopcap.NotAPcapFile {
+		return r, err
+	}
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return gopcap.NewNgReader(src)
+}
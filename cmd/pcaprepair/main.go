@@ -0,0 +1,61 @@
+// pcaprepair repairs a damaged .pcap file, writing a clean copy and reporting what was fixed.
+//
+// Usage:
+//
+//	pcaprepair damaged.pcap repaired.pcap
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/Lukasa/gopcap"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: pcaprepair <damaged.pcap> <repaired.pcap>")
+		os.Exit(2)
+	}
+
+	src, err := os.Open(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(os.Args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(dst)
+	report, err := gopcap.Repair(src, out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = dst.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to repair %v: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+
+	if report.HeaderRepaired {
+		fmt.Println("Rebuilt the file header.")
+	}
+	for _, skipped := range report.Skipped {
+		fmt.Printf("Skipped %v corrupt bytes at offset %v.\n", skipped.Length, skipped.Offset)
+	}
+	if report.Trimmed > 0 {
+		fmt.Printf("Trimmed %v packets cut short by the end of the file.\n", report.Trimmed)
+	}
+	if report.Dropped > 0 {
+		fmt.Printf("Dropped %v packets with no data.\n", report.Dropped)
+	}
+	fmt.Printf("Wrote %v packets to %v.\n", report.Packets, os.Args[2])
+}
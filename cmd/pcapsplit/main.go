@@ -0,0 +1,90 @@
+// pcapsplit splits a .pcap file into several smaller ones.
+//
+// Usage:
+//
+//	pcapsplit [-c packets] [-b bytes] [-i interval] capture.pcap prefix
+//	pcapsplit -flows capture.pcap prefix
+//
+// The first form starts a new file after the given number of packets or bytes, or for each time
+// interval (e.g. "10s" or "5m"), writing prefix_00000.pcap, prefix_00001.pcap and so on. The second
+// form writes each TCP or UDP conversation to its own file, named after the conversation's addresses
+// and ports, with everything else written to prefix_other.pcap.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Lukasa/gopcap"
+)
+
+func main() {
+	packets := flag.Int("c", 0, "the maximum number of packets in each file")
+	size := flag.Int64("b", 0, "the maximum size of each file in bytes")
+	interval := flag.Duration("i", 0, "the length of time covered by each file")
+	flows := flag.Bool("flows", false, "write each TCP or UDP conversation to its own file")
+	flag.Parse()
+
+	if flag.NArg() != 2 || (!*flows && *packets == 0 && *size == 0 && *interval == 0) {
+		fmt.Fprintln(os.Stderr, "Usage: pcapsplit [-c packets] [-b bytes] [-i interval] [-flows] <capture.pcap> <prefix>")
+		os.Exit(2)
+	}
+
+	src, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer src.Close()
+
+	r, err := gopcap.NewReader(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read %v: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	prefix := flag.Arg(1)
+	if *flows {
+		err = gopcap.SplitFlows(r, gopcap.SplitFlowsOptions{}, func(key gopcap.FlowKey, appending bool) (io.WriteCloser, error) {
+			name := fmt.Sprintf("%v_%v.pcap", prefix, flowName(key))
+			if appending {
+				return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+			}
+			return os.Create(name)
+		})
+	} else {
+		opts := gopcap.SplitOptions{Packets: *packets, Bytes: *size, Interval: *interval}
+		err = gopcap.Split(r, opts, func(n int) (io.WriteCloser, error) {
+			return os.Create(fmt.Sprintf("%v_%05d.pcap", prefix, n))
+		})
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to split %v: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// flowName turns a conversation's key into something usable in a file name, such as
+// "tcp_10.0.0.1_1234_10.0.0.2_80".
+func flowName(key gopcap.FlowKey) string {
+	if key == (gopcap.FlowKey{}) {
+		return "other"
+	}
+
+	protocol := fmt.Sprintf("ip%d", key.Protocol)
+	switch key.Protocol {
+	case gopcap.IPP_TCP:
+		protocol = "tcp"
+	case gopcap.IPP_UDP:
+		protocol = "udp"
+	}
+
+	name := fmt.Sprintf("%v_%v_%v_%v_%v", protocol, key.SourceAddress, key.SourcePort, key.DestinationAddress, key.DestinationPort)
+
+	// IPv6 addresses contain colons, which some filesystems don't allow.
+	return strings.ReplaceAll(name, ":", "-")
+}
@@ -0,0 +1,51 @@
+package gopcap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+// decompress checks the first few bytes of src for the magic numbers of the compression formats
+// gopcap understands: gzip, bzip2 and zlib. If one is found, it returns a reader that transparently
+// decompresses src. Otherwise it returns a reader that yields src unchanged.
+func decompress(src io.Reader) (*bufio.Reader, error) {
+	buffered := bufio.NewReader(src)
+
+	// Three bytes is enough for any of the compression magic numbers. A short read just means
+	// the source is too small to be compressed: any real error will show up again on the next
+	// read.
+	magic, _ := buffered.Peek(3)
+
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return buffered, err
+		}
+		return bufio.NewReader(gz), nil
+	} else if bytes.HasPrefix(magic, []byte("BZh")) {
+		return bufio.NewReader(bzip2.NewReader(buffered)), nil
+	} else if isZlibHeader(magic) {
+		zr, err := zlib.NewReader(buffered)
+		if err != nil {
+			return buffered, err
+		}
+		return bufio.NewReader(zr), nil
+	}
+
+	return buffered, nil
+}
+
+// isZlibHeader checks whether a buffer starts with a zlib header. zlib doesn't have a true magic
+// number: instead, the compression method must be deflate and the first two bytes, read as a
+// 16-bit integer, must be a multiple of 31.
+func isZlibHeader(magic []byte) bool {
+	if len(magic) < 2 {
+		return false
+	}
+
+	return (magic[0]&0x0F) == 8 && (getUint16(magic[0:2], false)%31) == 0
+}
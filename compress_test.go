@@ -0,0 +1,137 @@
+package gopcap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// A bzip2-compressed pcap file containing the single frame in ngTestFrame. The standard library
+// can't write bzip2, so this was compressed ahead of time.
+var bzip2TestFile = []byte{
+	0x42, 0x5A, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x46, 0x04, 0x70, 0xAD, 0x00, 0x00, 0x3B, 0x77, 0xFF, 0xF7, 0x58, 0x43, 0x70, 0xC0, 0x81,
+	0x42, 0xEB, 0x9D, 0x00, 0x4B, 0x67, 0x1B, 0x28, 0x50, 0x00, 0x01, 0x00, 0x20, 0x40, 0x50, 0x00, 0x48, 0x44, 0x85, 0x50, 0x1C, 0x12, 0x20, 0x00, 0xA0,
+	0x00, 0x74, 0x22, 0x9A, 0x46, 0x9A, 0x69, 0xA0, 0x0D, 0x00, 0x68, 0xC8, 0x34, 0x68, 0xF5, 0x1A, 0x69, 0x86, 0x86, 0xA1, 0xE9, 0x36, 0x9E, 0xA8, 0x45,
+	0x1E, 0x92, 0x34, 0xC1, 0x01, 0xA6, 0x98, 0x13, 0x43, 0x46, 0x4D, 0x01, 0xA6, 0x34, 0x99, 0x1A, 0x31, 0x18, 0xD8, 0x27, 0x10, 0x00, 0x97, 0x2A, 0x43,
+	0x16, 0x34, 0x00, 0xFC, 0x62, 0x0B, 0x26, 0x20, 0x8A, 0x83, 0x59, 0xA5, 0x38, 0x4A, 0x22, 0x61, 0xF5, 0x93, 0x13, 0x5D, 0x98, 0xB9, 0xC4, 0x0B, 0xF9,
+	0xD7, 0x1A, 0xEF, 0x23, 0xCE, 0xC2, 0x2C, 0x94, 0x96, 0x84, 0xFC, 0xD9, 0x2A, 0x20, 0xB6, 0x5C, 0x40, 0xF1, 0x2F, 0x3E, 0xB0, 0x6C, 0x34, 0xA0, 0x68,
+	0xF2, 0x34, 0x12, 0x98, 0x22, 0xC2, 0x13, 0x26, 0x25, 0x9B, 0xF4, 0x69, 0xC3, 0xE7, 0x49, 0x8F, 0xF5, 0x10, 0x53, 0x52, 0x50, 0x4A, 0x67, 0x3A, 0x5D,
+	0xA8, 0x68, 0x4D, 0x63, 0x8E, 0x4E, 0x15, 0x8F, 0xF1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x04, 0x60, 0x47, 0x0A, 0xD0,
+}
+
+func TestParseGzip(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	gz.Write(original)
+	gz.Close()
+
+	parsed, err := Parse(compressed)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(parsed.Packets) != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, len(parsed.Packets))
+	}
+}
+
+func TestParseZlib(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	compressed := new(bytes.Buffer)
+	zw := zlib.NewWriter(compressed)
+	zw.Write(original)
+	zw.Close()
+
+	parsed, err := Parse(compressed)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(parsed.Packets) != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, len(parsed.Packets))
+	}
+}
+
+func TestParseBzip2(t *testing.T) {
+	parsed, err := Parse(bytes.NewReader(bzip2TestFile))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(parsed.Packets) != 1 {
+		t.Fatalf("Unexpected number of packets: expected %v, got %v.", 1, len(parsed.Packets))
+	}
+
+	correct_ts := 1415577600*time.Second + 123456*time.Microsecond
+	if parsed.Packets[0].Timestamp != correct_ts {
+		t.Errorf("Unexpected TS: expected %v, got %v.", correct_ts, parsed.Packets[0].Timestamp)
+	}
+	if parsed.Packets[0].IncludedLen != uint32(len(ngTestFrame)) {
+		t.Errorf("Unexpected included length: expected %v, got %v.", len(ngTestFrame), parsed.Packets[0].IncludedLen)
+	}
+}
+
+func TestParseNgGzip(t *testing.T) {
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	w, _ := NewNgWriter(gz, NgSection{Interfaces: []NgInterface{NgInterface{LinkType: ETHERNET}}}, true)
+	w.WritePacket(NgPacket{}, ngTestFrame)
+	gz.Close()
+
+	parsed, err := ParseNg(compressed)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(parsed.Sections) != 1 || len(parsed.Sections[0].Packets) != 1 {
+		t.Errorf("Unexpected file contents: %+v", parsed)
+	}
+}
+
+func TestDecompressUncompressed(t *testing.T) {
+	in := [][]byte{
+		[]byte{0xa1, 0xb2, 0xc3, 0xd4, 0x00, 0x02},
+		[]byte{0x0a, 0x0d, 0x0d, 0x0a},
+		[]byte{0x1f},
+		[]byte{},
+	}
+
+	for _, input := range in {
+		src, err := decompress(bytes.NewReader(input))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+		out, _ := io.ReadAll(src)
+		if bytes.Compare(out, input) != 0 {
+			t.Errorf("Unexpected output: expected %v, got %v.", input, out)
+		}
+	}
+}
+
+func TestIsZlibHeader(t *testing.T) {
+	in := [][]byte{
+		[]byte{0x78, 0x01},
+		[]byte{0x78, 0x9c},
+		[]byte{0x78, 0xda},
+		[]byte{0x78, 0x00},
+		[]byte{0xa1, 0xb2},
+		[]byte{0x78},
+	}
+	out := []bool{true, true, true, false, false, false}
+
+	for i, input := range in {
+		if isZlibHeader(input) != out[i] {
+			t.Errorf("Unexpected result for %v: expected %v, got %v.", input, out[i], !out[i])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package gopcap
+
+import (
+	"time"
+)
+
+// TCPState is the state of a TCP connection, as far as can be told from the segments captured.
+type TCPState uint8
+
+const (
+	TCP_SYN_SENT     TCPState = iota // The initiator has sent a SYN
+	TCP_SYN_RECEIVED                 // The responder has replied with a SYN-ACK
+	TCP_ESTABLISHED                  // The handshake is complete, or was never seen
+	TCP_HALF_CLOSED                  // One side has sent a FIN
+	TCP_CLOSED                       // Both sides have sent a FIN
+	TCP_RESET                        // Either side has sent a RST
+	TCP_TIMED_OUT                    // The connection went quiet before it was closed
+)
+
+func (s TCPState) String() string {
+	switch s {
+	case TCP_SYN_SENT:
+		return "SYN sent"
+	case TCP_SYN_RECEIVED:
+		return "SYN received"
+	case TCP_ESTABLISHED:
+		return "established"
+	case TCP_HALF_CLOSED:
+		return "half-closed"
+	case TCP_CLOSED:
+		return "closed"
+	case TCP_RESET:
+		return "reset"
+	case TCP_TIMED_OUT:
+		return "timed out"
+	}
+	return "unknown"
+}
+
+// TCPEndpointStats counts what one side of a TCP connection has sent.
+type TCPEndpointStats struct {
+	Packets uint64
+	Bytes   uint64 // The number of bytes of payload, not counting headers, including any the capture cut off
+	FIN     bool   // Set once this side has sent a FIN
+}
+
+// TCPConnection is what a ConnectionTracker knows about a TCP connection.
+type TCPConnection struct {
+	Key               FlowKey // From the initiator to the responder
+	State             TCPState
+	HandshakeComplete bool      // Set once a SYN-ACK and the ACK for it have been seen
+	Midstream         bool      // Set if the connection was already open when the capture started, so the initiator is a guess
+	Start             time.Time // The time of the earliest segment
+	End               time.Time // The time of the latest segment
+	Initiator         TCPEndpointStats
+	Responder         TCPEndpointStats
+}
+
+// ConnectionTracker follows the state of TCP connections through their SYN, SYN-ACK, ACK, FIN and
+// RST flags, and counts the packets and bytes sent each way. The side that sent the first SYN is the
+// initiator. If the handshake wasn't captured, the side that sent the first segment is taken to be
+// the initiator instead.
+//
+// A connection stays in the tracker after it has closed or been reset, so that any segments that
+// follow are counted, until it is flushed, or a new SYN reuses its addresses and ports. Either way,
+// the done function is then called with the finished connection. A ConnectionTracker isn't safe for
+// concurrent use.
+type ConnectionTracker struct {
+	done        func(conn *TCPConnection)
+	connections map[FlowKey]*TCPConnection // Keyed by conversation
+}
+
+// NewConnectionTracker creates a ConnectionTracker that calls done with each connection once it's
+// finished with it.
+func NewConnectionTracker(done func(conn *TCPConnection)) *ConnectionTracker {
+	return &ConnectionTracker{done: done, connections: make(map[FlowKey]*TCPConnection)}
+}
+
+// Track updates the connection a packet belongs to, and returns it. It returns nil if the packet
+// isn't a TCP segment.
+func (c *ConnectionTracker) Track(pkt Packet) *TCPConnection {
+	key, ok := PacketFlow(pkt)
+	if !ok || key.Protocol != IPP_TCP {
+		return nil
+	}
+
+	return c.TrackSegment(key, pkt.Data.LinkData().InternetData().(*TCPSegment), pkt.Time)
+}
+
+// TrackSegment updates the connection that a TCP segment from key belongs to, and returns it. when
+// is the time the segment was captured.
+func (c *ConnectionTracker) TrackSegment(key FlowKey, segment *TCPSegment, when time.Time) *TCPConnection {
+	conversation := key.Conversation()
+	opening := segment.SYN && !segment.ACK
+
+	// A SYN after the connection has finished starts a new one between the same ports.
+	conn, ok := c.connections[conversation]
+	if ok && opening && (conn.State == TCP_CLOSED || conn.State == TCP_RESET) {
+		c.remove(conversation, conn)
+		ok = false
+	}
+
+	if !ok {
+		conn = &TCPConnection{Key: key, State: TCP_ESTABLISHED, Start: when, End: when}
+		switch {
+		case opening:
+			conn.State = TCP_SYN_SENT
+		case segment.SYN:
+			conn.Key, conn.State = key.Reverse(), TCP_SYN_RECEIVED
+		default:
+			conn.Midstream = true
+		}
+		c.connections[conversation] = conn
+	}
+
+	if when.Before(conn.Start) {
+		conn.Start = when
+	}
+	if when.After(conn.End) {
+		conn.End = when
+	}
+
+	fromInitiator := key == conn.Key
+	sender := &conn.Responder
+	if fromInitiator {
+		sender = &conn.Initiator
+	}
+	sender.Packets++
+	sender.Bytes += uint64(len(segment.TransportData()) + segment.missingPayload())
+
+	switch {
+	case segment.RST:
+		if conn.State != TCP_CLOSED {
+			conn.State = TCP_RESET
+		}
+	case conn.State == TCP_SYN_SENT && segment.SYN && segment.ACK && !fromInitiator:
+		conn.State = TCP_SYN_RECEIVED
+	case conn.State == TCP_SYN_RECEIVED && !segment.SYN && segment.ACK && fromInitiator:
+		conn.State, conn.HandshakeComplete = TCP_ESTABLISHED, true
+	}
+
+	if segment.FIN {
+		sender.FIN = true
+		if conn.State != TCP_RESET && conn.Initiator.FIN && conn.Responder.FIN {
+			conn.State = TCP_CLOSED
+		} else if conn.State != TCP_RESET {
+			conn.State = TCP_HALF_CLOSED
+		}
+	}
+
+	return conn
+}
+
+// FlushOlderThan finishes with the connections that haven't seen a segment since cutoff. Any that
+// hadn't closed or been reset are marked as timed out. It returns the number of connections
+// flushed.
+func (c *ConnectionTracker) FlushOlderThan(cutoff time.Time) int {
+	flushed := 0
+	for conversation, conn := range c.connections {
+		if conn.End.Before(cutoff) {
+			if conn.State != TCP_CLOSED && conn.State != TCP_RESET {
+				conn.State = TCP_TIMED_OUT
+			}
+			c.remove(conversation, conn)
+			flushed++
+		}
+	}
+	return flushed
+}
+
+// FlushAll finishes with every connection, as at the end of a capture. The connections keep the
+// state they were in. It returns the number of connections flushed.
+func (c *ConnectionTracker) FlushAll() int {
+	flushed := len(c.connections)
+	for conversation, conn := range c.connections {
+		c.remove(conversation, conn)
+	}
+	return flushed
+}
+
+// remove forgets a connection, and hands it to the done function.
+func (c *ConnectionTracker) remove(conversation FlowKey, conn *TCPConnection) {
+	delete(c.connections, conversation)
+	if c.done != nil {
+		c.done(conn)
+	}
+}
@@ -0,0 +1,153 @@
+package gopcap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConnectionTracker(t *testing.T) {
+	done := []*TCPConnection{}
+	c := NewConnectionTracker(func(conn *TCPConnection) { done = append(done, conn) })
+	reverse := streamTestKey.Reverse()
+
+	conn := c.TrackSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	if conn.State != TCP_SYN_SENT || conn.Key != streamTestKey || conn.Midstream {
+		t.Errorf("Unexpected connection: %+v", conn)
+	}
+
+	c.TrackSegment(reverse, streamSegment(4999, "SA", ""), defragTestTime.Add(time.Millisecond))
+	if conn.State != TCP_SYN_RECEIVED || conn.HandshakeComplete {
+		t.Errorf("Unexpected state: expected %v, got %v.", TCP_SYN_RECEIVED, conn.State)
+	}
+
+	c.TrackSegment(streamTestKey, streamSegment(1000, "A", ""), defragTestTime.Add(2*time.Millisecond))
+	if conn.State != TCP_ESTABLISHED || !conn.HandshakeComplete {
+		t.Errorf("Unexpected state: expected %v, got %v.", TCP_ESTABLISHED, conn.State)
+	}
+
+	c.TrackSegment(streamTestKey, streamSegment(1000, "A", "GET / HTTP/1.0\r\n\r\n"), defragTestTime.Add(3*time.Millisecond))
+	c.TrackSegment(reverse, streamSegment(5000, "AF", "HTTP/1.0 204 No Content\r\n\r\n"), defragTestTime.Add(4*time.Millisecond))
+	if conn.State != TCP_HALF_CLOSED || !conn.Responder.FIN {
+		t.Errorf("Unexpected state: expected %v, got %v.", TCP_HALF_CLOSED, conn.State)
+	}
+
+	c.TrackSegment(streamTestKey, streamSegment(1018, "AF", ""), defragTestTime.Add(5*time.Millisecond))
+	c.TrackSegment(reverse, streamSegment(5028, "A", ""), defragTestTime.Add(6*time.Millisecond))
+	if conn.State != TCP_CLOSED {
+		t.Errorf("Unexpected state: expected %v, got %v.", TCP_CLOSED, conn.State)
+	}
+
+	if conn.Initiator != (TCPEndpointStats{Packets: 4, Bytes: 18, FIN: true}) {
+		t.Errorf("Unexpected initiator stats: %+v", conn.Initiator)
+	}
+	if conn.Responder != (TCPEndpointStats{Packets: 3, Bytes: 27, FIN: true}) {
+		t.Errorf("Unexpected responder stats: %+v", conn.Responder)
+	}
+	if !conn.Start.Equal(defragTestTime) || conn.End.Sub(conn.Start) != 6*time.Millisecond {
+		t.Errorf("Unexpected times: %v to %v", conn.Start, conn.End)
+	}
+
+	// Reusing the ports finishes the old connection.
+	next := c.TrackSegment(streamTestKey, streamSegment(7000, "S", ""), defragTestTime.Add(time.Second))
+	if len(done) != 1 || done[0] != conn || next == conn || next.State != TCP_SYN_SENT {
+		t.Errorf("Unexpected connections: %v, %+v", done, next)
+	}
+}
+
+func TestConnectionTrackerReset(t *testing.T) {
+	c := NewConnectionTracker(nil)
+
+	conn := c.TrackSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	c.TrackSegment(streamTestKey.Reverse(), streamSegment(0, "RA", ""), defragTestTime)
+	if conn.State != TCP_RESET || conn.HandshakeComplete || conn.Responder.Packets != 1 {
+		t.Errorf("Unexpected connection: %+v", conn)
+	}
+
+	// A FIN after the reset doesn't change anything.
+	c.TrackSegment(streamTestKey, streamSegment(1000, "AF", ""), defragTestTime)
+	if conn.State != TCP_RESET {
+		t.Errorf("Unexpected state: expected %v, got %v.", TCP_RESET, conn.State)
+	}
+}
+
+// Without the SYN, the initiator is worked out from the SYN-ACK, or guessed from the first segment.
+func TestConnectionTrackerMidstream(t *testing.T) {
+	c := NewConnectionTracker(nil)
+
+	conn := c.TrackSegment(streamTestKey.Reverse(), streamSegment(4999, "SA", ""), defragTestTime)
+	if conn.Key != streamTestKey || conn.State != TCP_SYN_RECEIVED || conn.Midstream || conn.Responder.Packets != 1 {
+		t.Errorf("Unexpected connection: %+v", conn)
+	}
+
+	other := streamTestKey
+	other.SourcePort = 1025
+	conn = c.TrackSegment(other.Reverse(), streamSegment(5000, "A", "data"), defragTestTime)
+	if conn.Key != other.Reverse() || conn.State != TCP_ESTABLISHED || !conn.Midstream || conn.HandshakeComplete {
+		t.Errorf("Unexpected connection: %+v", conn)
+	}
+}
+
+// Payload bytes that the capture cut off are still counted.
+func TestConnectionTrackerTruncated(t *testing.T) {
+	c := NewConnectionTracker(nil)
+
+	segment := streamSegment(1000, "A", "da")
+	segment.HeaderSize, segment.Truncated, segment.MissingBytes = 6, true, 10
+	conn := c.TrackSegment(streamTestKey, segment, defragTestTime)
+	if conn.Initiator.Bytes != 8 {
+		t.Errorf("Unexpected bytes: expected %v, got %v", 8, conn.Initiator.Bytes)
+	}
+}
+
+func TestConnectionTrackerFlush(t *testing.T) {
+	done := []*TCPConnection{}
+	c := NewConnectionTracker(func(conn *TCPConnection) { done = append(done, conn) })
+	other := streamTestKey
+	other.SourcePort = 1025
+
+	old := c.TrackSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	recent := c.TrackSegment(other, streamSegment(999, "S", ""), defragTestTime.Add(time.Minute))
+
+	if c.FlushOlderThan(defragTestTime.Add(time.Second)) != 1 || len(done) != 1 || old.State != TCP_TIMED_OUT {
+		t.Errorf("Unexpected connection: %+v", old)
+	}
+	if c.FlushAll() != 1 || len(done) != 2 || recent.State != TCP_SYN_SENT {
+		t.Errorf("Unexpected connection: %+v", recent)
+	}
+}
+
+// Every TCP segment in the test file should be counted once.
+func TestConnectionTrackerFile(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	var packets, counted uint64
+	states := make(map[TCPState]int)
+	c := NewConnectionTracker(func(conn *TCPConnection) {
+		counted += conn.Initiator.Packets + conn.Responder.Packets
+		states[conn.State]++
+	})
+
+	r, _ := NewReader(bytes.NewReader(original))
+	for {
+		pkt, err := r.Next()
+		if err != nil {
+			break
+		}
+		if c.Track(pkt) != nil {
+			packets++
+		}
+	}
+	c.FlushAll()
+
+	if packets == 0 || counted != packets {
+		t.Errorf("Unexpected packet count: expected %v, got %v.", packets, counted)
+	}
+	if states[TCP_CLOSED] == 0 || states[TCP_RESET] == 0 {
+		t.Errorf("Unexpected states: %v", states)
+	}
+}
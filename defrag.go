@@ -0,0 +1,411 @@
+package gopcap
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"sort"
+	"time"
+)
+
+var InvalidFragment error = errors.New("Invalid IP fragment.")
+var IncompleteFragment error = errors.New("IP fragment was truncated by the capture.")
+
+// DefragOptions configures a defragmenter. Fragments are held until the rest of their packet
+// arrives, so the limits here bound how long that can take and how much memory it can use. When a
+// limit is reached, the packets that started arriving first are thrown away to make room.
+type DefragOptions struct {
+	Timeout      time.Duration // How long after its first fragment a packet must be complete. Defaults to 30s.
+	MaxDatagrams int           // The most packets that can be reassembled at once. Defaults to 1024.
+	MaxBytes     int           // The most fragment data that can be held at once. Defaults to 4MB.
+}
+
+// withDefaults fills in the default for any option that is left as zero.
+func (o DefragOptions) withDefaults() DefragOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = 30 * time.Second
+	}
+	if o.MaxDatagrams <= 0 {
+		o.MaxDatagrams = 1024
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 4 << 20
+	}
+	return o
+}
+
+//-------------------------------------------------------------------------------------------
+// Fragment buffers
+//-------------------------------------------------------------------------------------------
+
+// fragment is one piece of a packet that is being reassembled.
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// fragmentBuffer collects the fragments of a single packet.
+type fragmentBuffer struct {
+	fragments []fragment    // In the order they arrived
+	total     int           // The length of the whole payload, or -1 until the last fragment arrives
+	end       int           // The end of the furthest fragment so far
+	size      int           // The number of bytes held
+	first     time.Time     // When the first fragment arrived
+	header    []byte        // The header of the fragment at offset zero, once it arrives
+	discarded bool          // Set if the packet has been thrown away, but its fragments are still arriving
+	element   *list.Element // The buffer's place in the fragmentTable's order
+}
+
+// add adds a fragment to the buffer, copying its data. It returns InvalidFragment if the fragment
+// doesn't fit with the ones that came before.
+func (f *fragmentBuffer) add(offset int, data []byte, last bool) error {
+	end := offset + len(data)
+
+	if last {
+		if (f.total >= 0 && f.total != end) || end < f.end {
+			return InvalidFragment
+		}
+		f.total = end
+	} else if f.total >= 0 && end > f.total {
+		return InvalidFragment
+	}
+
+	f.fragments = append(f.fragments, fragment{offset: offset, data: append([]byte{}, data...)})
+	f.size += len(data)
+	if end > f.end {
+		f.end = end
+	}
+	return nil
+}
+
+// overlaps reports whether a fragment overlaps any that have already arrived, and if so, whether it
+// is an exact duplicate of one of them.
+func (f *fragmentBuffer) overlaps(offset int, data []byte) (bool, bool) {
+	end := offset + len(data)
+	overlap := false
+
+	for _, frag := range f.fragments {
+		if frag.offset == offset && bytes.Equal(frag.data, data) {
+			return true, true
+		}
+		if offset < frag.offset+len(frag.data) && frag.offset < end {
+			overlap = true
+		}
+	}
+	return overlap, false
+}
+
+// complete reports whether every byte of the packet has arrived.
+func (f *fragmentBuffer) complete() bool {
+	if f.total < 0 {
+		return false
+	}
+
+	sorted := append([]fragment{}, f.fragments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+
+	covered := 0
+	for _, frag := range sorted {
+		if frag.offset > covered {
+			return false
+		}
+		if end := frag.offset + len(frag.data); end > covered {
+			covered = end
+		}
+	}
+	return covered >= f.total
+}
+
+// assemble joins the fragments back together. Where fragments overlap, the data that arrived first
+// wins.
+func (f *fragmentBuffer) assemble() []byte {
+	payload := make([]byte, f.total)
+	for i := len(f.fragments) - 1; i >= 0; i-- {
+		copy(payload[f.fragments[i].offset:], f.fragments[i].data)
+	}
+	return payload
+}
+
+// fragmentTable holds the fragmentBuffers for all the packets being reassembled, enforcing the
+// limits in DefragOptions. The keys can be of any comparable type.
+type fragmentTable struct {
+	opts    DefragOptions
+	buffers map[interface{}]*fragmentBuffer
+	order   *list.List // The keys of buffers, in the order their first fragments arrived
+	size    int
+}
+
+func newFragmentTable(opts DefragOptions) *fragmentTable {
+	return &fragmentTable{opts: opts.withDefaults(), buffers: make(map[interface{}]*fragmentBuffer), order: list.New()}
+}
+
+// expire throws away the packets that have been waiting for longer than the timeout.
+func (t *fragmentTable) expire(now time.Time) {
+	for t.order.Len() > 0 {
+		key := t.order.Front().Value
+		if now.Sub(t.buffers[key].first) <= t.opts.Timeout {
+			break
+		}
+		t.remove(key)
+	}
+}
+
+// get returns the buffer for a key, creating it if needed. Creating it may throw away the oldest
+// packet if there are too many.
+func (t *fragmentTable) get(key interface{}, now time.Time) *fragmentBuffer {
+	buf, ok := t.buffers[key]
+	if ok {
+		return buf
+	}
+
+	for t.order.Len() >= t.opts.MaxDatagrams {
+		t.remove(t.order.Front().Value)
+	}
+
+	buf = &fragmentBuffer{total: -1, first: now}
+	buf.element = t.order.PushBack(key)
+	t.buffers[key] = buf
+	return buf
+}
+
+// add adds a fragment to the buffer for a key, and then throws away the oldest packets until the
+// table is back under its size limit. It reports whether the key's packet is still being held. The
+// key's buffer is removed if the fragment is invalid.
+func (t *fragmentTable) add(key interface{}, buf *fragmentBuffer, offset int, data []byte, last bool) (bool, error) {
+	err := buf.add(offset, data, last)
+	if err != nil {
+		t.remove(key)
+		return false, err
+	}
+	t.size += len(data)
+
+	for t.size > t.opts.MaxBytes {
+		t.remove(t.order.Front().Value)
+	}
+
+	_, held := t.buffers[key]
+	return held, nil
+}
+
+// discard throws away the fragments held for a key, but remembers that the packet was discarded
+// until it times out, so that the rest of its fragments can be thrown away too.
+func (t *fragmentTable) discard(key interface{}) {
+	buf, ok := t.buffers[key]
+	if !ok {
+		return
+	}
+
+	t.size -= buf.size
+	buf.fragments, buf.header, buf.size, buf.discarded = nil, nil, 0, true
+}
+
+// remove throws away the buffer for a key.
+func (t *fragmentTable) remove(key interface{}) {
+	buf, ok := t.buffers[key]
+	if !ok {
+		return
+	}
+
+	t.order.Remove(buf.element)
+	t.size -= buf.size
+	delete(t.buffers, key)
+}
+
+//-------------------------------------------------------------------------------------------
+// IPv4
+//-------------------------------------------------------------------------------------------
+
+// ipv4FragmentKey identifies the fragments that belong to the same IPv4 packet.
+type ipv4FragmentKey struct {
+	source      [4]byte
+	destination [4]byte
+	protocol    IPProtocol
+	id          uint16
+}
+
+// IPv4Defragmenter reassembles fragmented IPv4 packets. Fragments are matched up by their source
+// and destination addresses, protocol and ID, and may arrive in any order. Where fragments overlap,
+// the data that arrived first is kept. Time is measured by the capture times passed to Defrag, so
+// captures can be processed faster than real time.
+type IPv4Defragmenter struct {
+	table *fragmentTable
+}
+
+// NewIPv4Defragmenter creates an IPv4Defragmenter with the given limits.
+func NewIPv4Defragmenter(opts DefragOptions) *IPv4Defragmenter {
+	return &IPv4Defragmenter{table: newFragmentTable(opts)}
+}
+
+// Defrag takes an IPv4 packet, decoded from a capture, and the time it was captured. If the packet
+// isn't a fragment, it is returned unchanged. If it's the fragment that completes a packet, the
+// whole packet is returned, with its transport layer decoded. Its header is that of the first
+// fragment, with the length, flags and checksum updated to match. Otherwise, Defrag holds onto the
+// fragment and returns nil.
+//
+// Fragments that were truncated by the capture can't be reassembled, and return IncompleteFragment.
+// Fragments that don't fit with the rest of their packet return InvalidFragment, and the packet is
+// thrown away.
+func (d *IPv4Defragmenter) Defrag(pkt *IPv4Packet, when time.Time) (*IPv4Packet, error) {
+	if !pkt.MoreFragments && pkt.FragmentOffset == 0 {
+		return pkt, nil
+	}
+
+	d.table.expire(when)
+
+	if pkt.Truncated || pkt.header == nil {
+		return nil, IncompleteFragment
+	}
+
+	// All but the last fragment must hold a multiple of eight bytes, and the whole packet must fit
+	// in the 16-bit total length.
+	offset := int(pkt.FragmentOffset) * 8
+	if (pkt.MoreFragments && len(pkt.payload)%8 != 0) || len(pkt.header)+offset+len(pkt.payload) > 0xFFFF {
+		return nil, InvalidFragment
+	}
+
+	key := ipv4FragmentKey{protocol: pkt.Protocol, id: pkt.ID}
+	copy(key.source[:], pkt.SourceAddress)
+	copy(key.destination[:], pkt.DestAddress)
+
+	buf := d.table.get(key, when)
+	held, err := d.table.add(key, buf, offset, pkt.payload, !pkt.MoreFragments)
+	if err != nil || !held {
+		return nil, err
+	}
+
+	if offset == 0 && buf.header == nil {
+		buf.header = append([]byte{}, pkt.header...)
+	}
+	if buf.header == nil || !buf.complete() {
+		return nil, nil
+	}
+
+	d.table.remove(key)
+	data := append(buf.header, buf.assemble()...)
+	if len(data) > 0xFFFF {
+		return nil, InvalidFragment
+	}
+
+	// Turn the first fragment's header into one for the whole packet: fix the length, clear the
+	// More Fragments flag and the offset, and recompute the checksum.
+	putUint16(data[2:4], uint16(len(data)), false)
+	putUint16(data[6:8], getUint16(data[6:8], false)&0x4000, false)
+	putUint16(data[10:12], 0, false)
+	putUint16(data[10:12], foldChecksum(sumBytes(data[:len(buf.header)], 0)), false)
+
+	whole := new(IPv4Packet)
+	err = whole.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return whole, nil
+}
+
+//-------------------------------------------------------------------------------------------
+// IPv6
+//-------------------------------------------------------------------------------------------
+
+// ipv6FragmentKey identifies the fragments that belong to the same IPv6 packet.
+type ipv6FragmentKey struct {
+	source      [16]byte
+	destination [16]byte
+	id          uint32
+}
+
+// IPv6Defragmenter reassembles fragmented IPv6 packets. Fragments are matched up by their source
+// and destination addresses and identification, and may arrive in any order. As RFC 5722 requires,
+// a packet with overlapping fragments is thrown away, along with any more of its fragments that
+// arrive before it times out. Exact duplicates of a fragment are ignored instead. Time is measured
+// by the capture times passed to Defrag, so captures can be processed faster than real time.
+type IPv6Defragmenter struct {
+	table *fragmentTable
+}
+
+// NewIPv6Defragmenter creates an IPv6Defragmenter with the given limits.
+func NewIPv6Defragmenter(opts DefragOptions) *IPv6Defragmenter {
+	return &IPv6Defragmenter{table: newFragmentTable(opts)}
+}
+
+// Defrag takes an IPv6 packet, decoded from a capture, and the time it was captured. If the packet
+// has no Fragment header, it is returned unchanged, as are atomic fragments (RFC 6946), whose
+// Fragment header says they are the whole packet. If it's the fragment that completes a packet, the
+// whole packet is returned, with its transport layer decoded. It has the headers that came before
+// the Fragment header in the first fragment, with the Fragment header removed and the length
+// updated to match. Otherwise, Defrag holds onto the fragment and returns nil.
+//
+// Fragments that were truncated by the capture, or that weren't decoded from bytes, can't be
+// reassembled, and return IncompleteFragment. Fragments that don't fit with the rest of their
+// packet, or overlap another fragment, return InvalidFragment, and the packet is thrown away.
+func (d *IPv6Defragmenter) Defrag(pkt *IPv6Packet, when time.Time) (*IPv6Packet, error) {
+	var frag *IPv6Fragment
+	for _, ext := range pkt.ExtensionHeaders {
+		if h, ok := ext.(*IPv6Fragment); ok {
+			frag = h
+			break
+		}
+	}
+
+	if frag == nil || (!frag.MoreFragments && frag.FragmentOffset == 0) {
+		return pkt, nil
+	}
+
+	d.table.expire(when)
+
+	if pkt.Truncated || pkt.raw == nil || pkt.fragment == 0 {
+		return nil, IncompleteFragment
+	}
+
+	// All but the last fragment must hold a multiple of eight bytes, and the whole packet must fit
+	// in the 16-bit payload length.
+	payload := pkt.raw[pkt.fragment+8:]
+	offset := int(frag.FragmentOffset) * 8
+	if (frag.MoreFragments && len(payload)%8 != 0) || pkt.fragment-40+offset+len(payload) > 0xFFFF {
+		return nil, InvalidFragment
+	}
+
+	key := ipv6FragmentKey{id: frag.ID}
+	copy(key.source[:], pkt.SourceAddress)
+	copy(key.destination[:], pkt.DestinationAddress)
+
+	buf := d.table.get(key, when)
+	if buf.discarded {
+		return nil, InvalidFragment
+	}
+
+	overlap, duplicate := buf.overlaps(offset, payload)
+	if duplicate {
+		return nil, nil
+	} else if overlap {
+		d.table.discard(key)
+		return nil, InvalidFragment
+	}
+
+	held, err := d.table.add(key, buf, offset, payload, !frag.MoreFragments)
+	if err != nil || !held {
+		return nil, err
+	}
+
+	// The whole packet keeps the headers that come before the Fragment header, with the header that
+	// pointed to it pointing to whatever came after it instead.
+	if offset == 0 && buf.header == nil {
+		buf.header = append([]byte{}, pkt.raw[:pkt.fragment]...)
+		buf.header[pkt.fragmentNext] = uint8(frag.NextHeader)
+	}
+	if buf.header == nil || !buf.complete() {
+		return nil, nil
+	}
+
+	d.table.remove(key)
+	data := append(buf.header, buf.assemble()...)
+	if len(data)-40 > 0xFFFF {
+		return nil, InvalidFragment
+	}
+	putUint16(data[4:6], uint16(len(data)-40), false)
+
+	whole := new(IPv6Packet)
+	err = whole.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return whole, nil
+}
@@ -0,0 +1,398 @@
+package gopcap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var defragTestTime = time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+
+// defragTestPacket builds an IPv4 UDP packet with an n-byte payload.
+func defragTestPacket(id uint16, n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	data, _ := NewPacketBuilder().
+		IPv4(&IPv4Packet{ID: id, SourceAddress: []byte{10, 0, 0, 1}, DestAddress: []byte{10, 0, 0, 2}}).
+		UDP(&UDPDatagram{SourcePort: 2049, DestinationPort: 2049}).
+		Payload(payload).
+		Build()
+	return data
+}
+
+// fragmentIPv4 splits an IPv4 packet with a 20-byte header into fragments at the given offsets into
+// its payload, and decodes them.
+func fragmentIPv4(data []byte, offsets ...int) []*IPv4Packet {
+	payload := data[20:]
+	offsets = append(offsets, len(payload))
+	fragments := []*IPv4Packet{}
+
+	start := 0
+	for i, end := range offsets {
+		frag := append(append([]byte{}, data[:20]...), payload[start:end]...)
+		flags := uint16(start / 8)
+		if i < len(offsets)-1 {
+			flags |= 0x2000
+		}
+		putUint16(frag[2:4], uint16(len(frag)), false)
+		putUint16(frag[6:8], flags, false)
+		putUint16(frag[10:12], 0, false)
+		putUint16(frag[10:12], foldChecksum(sumBytes(frag[:20], 0)), false)
+
+		pkt := new(IPv4Packet)
+		pkt.FromBytes(frag)
+		fragments = append(fragments, pkt)
+		start = end
+	}
+	return fragments
+}
+
+// checkReassembled checks that a reassembled packet matches the original.
+func checkReassembled(t *testing.T, whole *IPv4Packet, original []byte) {
+	if whole == nil {
+		t.Fatalf("Packet wasn't reassembled.")
+	}
+	if whole.MoreFragments || whole.FragmentOffset != 0 || int(whole.TotalLength) != len(original) {
+		t.Errorf("Unexpected header: %+v", whole)
+	}
+	if whole.VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected IPv4 checksum status: %v", whole.VerifyChecksum())
+	}
+
+	udp, ok := whole.InternetData().(*UDPDatagram)
+	if !ok {
+		t.Fatalf("Unexpected transport layer: %+v", whole.InternetData())
+	}
+	if bytes.Compare(udp.TransportData(), original[28:]) != 0 {
+		t.Errorf("Unexpected payload: expected %x, got %x.", original[28:], udp.TransportData())
+	}
+	if udp.VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected UDP checksum status: %v", udp.VerifyChecksum())
+	}
+}
+
+func TestIPv4Defrag(t *testing.T) {
+	original := defragTestPacket(1, 3000)
+	fragments := fragmentIPv4(original, 1480, 2960)
+	d := NewIPv4Defragmenter(DefragOptions{})
+
+	// Later fragments don't start with a UDP header.
+	if _, ok := fragments[1].InternetData().(*UnknownTransport); !ok {
+		t.Errorf("Unexpected transport layer: %+v", fragments[1].InternetData())
+	}
+
+	for i, frag := range fragments[:2] {
+		whole, err := d.Defrag(frag, defragTestTime)
+		if whole != nil || err != nil {
+			t.Errorf("Unexpected result for fragment %v: %v, %v", i, whole, err)
+		}
+	}
+
+	whole, err := d.Defrag(fragments[2], defragTestTime)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	checkReassembled(t, whole, original)
+}
+
+func TestIPv4DefragOutOfOrder(t *testing.T) {
+	original := defragTestPacket(1, 100)
+	fragments := fragmentIPv4(original, 16, 48, 80)
+	d := NewIPv4Defragmenter(DefragOptions{})
+
+	var whole *IPv4Packet
+	for _, i := range []int{3, 1, 0, 2} {
+		whole, _ = d.Defrag(fragments[i], defragTestTime)
+	}
+	checkReassembled(t, whole, original)
+}
+
+// Packets that aren't fragments come straight back.
+func TestIPv4DefragUnfragmented(t *testing.T) {
+	pkt := new(IPv4Packet)
+	pkt.FromBytes(defragTestPacket(1, 100))
+
+	whole, err := NewIPv4Defragmenter(DefragOptions{}).Defrag(pkt, defragTestTime)
+	if whole != pkt || err != nil {
+		t.Errorf("Unexpected result: %v, %v", whole, err)
+	}
+}
+
+// Where fragments overlap, the first one wins.
+func TestIPv4DefragOverlap(t *testing.T) {
+	original := defragTestPacket(1, 100)
+	fragments := fragmentIPv4(original, 48)
+
+	corrupt := append([]byte{}, original...)
+	for i := 68; i < len(corrupt); i++ {
+		corrupt[i] = 0xFF
+	}
+	overlap := fragmentIPv4(corrupt, 32)
+
+	d := NewIPv4Defragmenter(DefragOptions{})
+	d.Defrag(fragments[1], defragTestTime)
+	d.Defrag(overlap[1], defragTestTime)
+	whole, err := d.Defrag(fragments[0], defragTestTime)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	checkReassembled(t, whole, original)
+}
+
+func TestIPv4DefragTimeout(t *testing.T) {
+	fragments := fragmentIPv4(defragTestPacket(1, 100), 48)
+	d := NewIPv4Defragmenter(DefragOptions{Timeout: time.Second})
+
+	d.Defrag(fragments[0], defragTestTime)
+	whole, err := d.Defrag(fragments[1], defragTestTime.Add(2*time.Second))
+	if whole != nil || err != nil {
+		t.Errorf("Unexpected result: %v, %v", whole, err)
+	}
+}
+
+func TestIPv4DefragLimits(t *testing.T) {
+	first := fragmentIPv4(defragTestPacket(1, 100), 48)
+	second := fragmentIPv4(defragTestPacket(2, 100), 48)
+
+	// With room for one packet, starting a second throws away the first.
+	d := NewIPv4Defragmenter(DefragOptions{MaxDatagrams: 1})
+	d.Defrag(first[0], defragTestTime)
+	d.Defrag(second[0], defragTestTime)
+	whole, _ := d.Defrag(second[1], defragTestTime)
+	if whole == nil || whole.ID != 2 {
+		t.Errorf("Unexpected packet: %+v", whole)
+	}
+	whole, _ = d.Defrag(first[1], defragTestTime)
+	if whole != nil {
+		t.Errorf("Unexpected packet: %+v", whole)
+	}
+
+	// A packet bigger than the memory limit is thrown away.
+	d = NewIPv4Defragmenter(DefragOptions{MaxBytes: 64})
+	d.Defrag(first[0], defragTestTime)
+	whole, err := d.Defrag(first[1], defragTestTime)
+	if whole != nil || err != nil {
+		t.Errorf("Unexpected result: %v, %v", whole, err)
+	}
+	if d.table.size != 0 || d.table.order.Len() != 0 {
+		t.Errorf("Unexpected table: %v bytes, %v packets.", d.table.size, d.table.order.Len())
+	}
+}
+
+func TestIPv4DefragInvalid(t *testing.T) {
+	original := defragTestPacket(1, 100)
+	d := NewIPv4Defragmenter(DefragOptions{})
+
+	// Fragments other than the last must be a multiple of eight bytes long.
+	fragments := fragmentIPv4(original, 50)
+	_, err := d.Defrag(fragments[0], defragTestTime)
+	if err != InvalidFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidFragment, err)
+	}
+
+	// Two last fragments that disagree about the length throw the packet away.
+	fragments = fragmentIPv4(original, 48)
+	shorter := fragmentIPv4(original[:100], 48)
+	d.Defrag(fragments[1], defragTestTime)
+	_, err = d.Defrag(shorter[1], defragTestTime)
+	if err != InvalidFragment || d.table.order.Len() != 0 {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidFragment, err)
+	}
+
+	// Truncated fragments can't be used.
+	fragments = fragmentIPv4(original, 48)
+	truncated := new(IPv4Packet)
+	truncated.FromBytes(append(append([]byte{}, fragments[0].header...), fragments[0].payload[:40]...))
+	_, err = d.Defrag(truncated, defragTestTime)
+	if err != IncompleteFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", IncompleteFragment, err)
+	}
+}
+
+// defragTestPacketIPv6 builds an IPv6 UDP packet with an n-byte payload, and a Hop-by-Hop header if
+// hopByHop is set.
+func defragTestPacketIPv6(n int, hopByHop bool) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	ip := &IPv6Packet{SourceAddress: bytes.Repeat([]byte{0x20}, 16), DestinationAddress: bytes.Repeat([]byte{0xFE}, 16)}
+	if hopByHop {
+		ip.ExtensionHeaders = []IPv6ExtensionHeader{&IPv6HopByHop{NextHeader: IPP_UDP, Options: []IPv6Option{{Type: 5, Data: []byte{0, 0}}}}}
+	}
+
+	data, _ := NewPacketBuilder().
+		IPv6(ip).
+		UDP(&UDPDatagram{SourcePort: 2049, DestinationPort: 2049}).
+		Payload(payload).
+		Build()
+	return data
+}
+
+// fragmentIPv6 splits an IPv6 packet into fragments at the given offsets into the part after the
+// first unfragmentable bytes, and decodes them.
+func fragmentIPv6(data []byte, unfragmentable int, offsets ...int) []*IPv6Packet {
+	payload := data[unfragmentable:]
+	offsets = append(offsets, len(payload))
+	fragments := []*IPv6Packet{}
+
+	// The Fragment header goes after the last unfragmentable header, and points to whatever that
+	// header pointed to.
+	pointer := 6
+	if unfragmentable > 40 {
+		pointer = 40
+	}
+
+	start := 0
+	for i, end := range offsets {
+		header := &IPv6Fragment{NextHeader: IPProtocol(data[pointer]), FragmentOffset: uint16(start / 8), MoreFragments: i < len(offsets)-1, ID: 0x1234}
+		frag := append([]byte{}, data[:unfragmentable]...)
+		frag[pointer] = uint8(IPP_IPV6_FRAG)
+		frag, _ = header.AppendBytes(frag, SerializeOptions{})
+		frag = append(frag, payload[start:end]...)
+		putUint16(frag[4:6], uint16(len(frag)-40), false)
+
+		pkt := new(IPv6Packet)
+		pkt.FromBytes(frag)
+		fragments = append(fragments, pkt)
+		start = end
+	}
+	return fragments
+}
+
+// checkReassembledIPv6 checks that a reassembled packet matches the original.
+func checkReassembledIPv6(t *testing.T, whole *IPv6Packet, original []byte) {
+	if whole == nil {
+		t.Fatalf("Packet wasn't reassembled.")
+	}
+
+	out, _ := ToBytes(whole, SerializeOptions{})
+	if bytes.Compare(out, original) != 0 {
+		t.Errorf("Unexpected bytes: expected %x, got %x.", original, out)
+	}
+
+	udp, ok := whole.InternetData().(*UDPDatagram)
+	if !ok {
+		t.Fatalf("Unexpected transport layer: %+v", whole.InternetData())
+	}
+	if udp.VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected UDP checksum status: %v", udp.VerifyChecksum())
+	}
+}
+
+func TestIPv6Defrag(t *testing.T) {
+	original := defragTestPacketIPv6(3000, false)
+	fragments := fragmentIPv6(original, 40, 1232, 2464)
+	d := NewIPv6Defragmenter(DefragOptions{})
+
+	for i, frag := range fragments[:2] {
+		whole, err := d.Defrag(frag, defragTestTime)
+		if whole != nil || err != nil {
+			t.Errorf("Unexpected result for fragment %v: %v, %v", i, whole, err)
+		}
+	}
+
+	whole, err := d.Defrag(fragments[2], defragTestTime)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	checkReassembledIPv6(t, whole, original)
+	if whole.NextHeader != IPP_UDP || len(whole.ExtensionHeaders) != 0 {
+		t.Errorf("Unexpected header: %+v", whole)
+	}
+}
+
+// The headers before the Fragment header are kept, and fragments can arrive in any order. Exact
+// duplicates are ignored.
+func TestIPv6DefragHeaders(t *testing.T) {
+	original := defragTestPacketIPv6(100, true)
+	fragments := fragmentIPv6(original, 48, 16, 48, 80)
+	d := NewIPv6Defragmenter(DefragOptions{})
+
+	var whole *IPv6Packet
+	var err error
+	for _, i := range []int{3, 1, 1, 0, 2} {
+		whole, err = d.Defrag(fragments[i], defragTestTime)
+		if err != nil {
+			t.Errorf("Unexpected error for fragment %v: %v", i, err)
+		}
+	}
+	checkReassembledIPv6(t, whole, original)
+
+	hop, ok := whole.ExtensionHeaders[0].(*IPv6HopByHop)
+	if len(whole.ExtensionHeaders) != 1 || !ok || hop.NextHeader != IPP_UDP {
+		t.Errorf("Unexpected extension headers: %v", whole.ExtensionHeaders)
+	}
+}
+
+// Atomic fragments are complete packets already.
+func TestIPv6DefragAtomic(t *testing.T) {
+	fragments := fragmentIPv6(defragTestPacketIPv6(100, false), 40)
+
+	whole, err := NewIPv6Defragmenter(DefragOptions{}).Defrag(fragments[0], defragTestTime)
+	if whole != fragments[0] || err != nil {
+		t.Errorf("Unexpected result: %v, %v", whole, err)
+	}
+	if whole.InternetData().(*UDPDatagram).VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected UDP checksum status: %v", whole.InternetData().(*UDPDatagram).VerifyChecksum())
+	}
+}
+
+// Overlapping fragments throw away the whole packet, including fragments that are still to come.
+func TestIPv6DefragOverlap(t *testing.T) {
+	original := defragTestPacketIPv6(100, false)
+	fragments := fragmentIPv6(original, 40, 48)
+	overlap := fragmentIPv6(original, 40, 32)
+	d := NewIPv6Defragmenter(DefragOptions{})
+
+	d.Defrag(fragments[1], defragTestTime)
+	_, err := d.Defrag(overlap[1], defragTestTime)
+	if err != InvalidFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidFragment, err)
+	}
+	if d.table.size != 0 {
+		t.Errorf("Unexpected table size: %v", d.table.size)
+	}
+
+	whole, err := d.Defrag(fragments[0], defragTestTime)
+	if whole != nil || err != InvalidFragment {
+		t.Errorf("Unexpected result: %v, %v", whole, err)
+	}
+
+	// Once it has timed out, the packet can arrive again.
+	d.Defrag(fragments[0], defragTestTime.Add(time.Minute))
+	whole, _ = d.Defrag(fragments[1], defragTestTime.Add(time.Minute))
+	checkReassembledIPv6(t, whole, original)
+}
+
+func TestIPv6DefragInvalid(t *testing.T) {
+	original := defragTestPacketIPv6(100, false)
+	d := NewIPv6Defragmenter(DefragOptions{})
+
+	// Fragments other than the last must be a multiple of eight bytes long.
+	fragments := fragmentIPv6(original, 40, 50)
+	_, err := d.Defrag(fragments[0], defragTestTime)
+	if err != InvalidFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidFragment, err)
+	}
+
+	// Truncated fragments can't be used.
+	fragments = fragmentIPv6(original, 40, 48)
+	truncated := new(IPv6Packet)
+	truncated.FromBytes(fragments[0].raw[:80])
+	_, err = d.Defrag(truncated, defragTestTime)
+	if err != IncompleteFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", IncompleteFragment, err)
+	}
+
+	// Neither can fragments that weren't decoded from bytes.
+	built := &IPv6Packet{ExtensionHeaders: []IPv6ExtensionHeader{&IPv6Fragment{NextHeader: IPP_UDP, MoreFragments: true}}}
+	_, err = d.Defrag(built, defragTestTime)
+	if err != IncompleteFragment {
+		t.Errorf("Unexpected error: expected %v, got %v", IncompleteFragment, err)
+	}
+}
@@ -0,0 +1,100 @@
+package gopcap
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// FlowKey identifies a TCP or UDP flow by its 5-tuple. FlowKeys can be compared with == and used as
+// map keys. A FlowKey describes one direction of a conversation: use Conversation to get a key that
+// is the same for both directions.
+type FlowKey struct {
+	Protocol           IPProtocol
+	SourceAddress      netip.Addr
+	DestinationAddress netip.Addr
+	SourcePort         uint16
+	DestinationPort    uint16
+}
+
+// PacketFlow returns the FlowKey of a TCP or UDP packet carried over IPv4 or IPv6. The second return
+// value is false if the packet isn't one of those, if it's a later fragment of a fragmented IP
+// packet, which carries no ports, or if the capture stopped before the end of its ports.
+func PacketFlow(pkt Packet) (FlowKey, bool) {
+	key := FlowKey{}
+
+	if pkt.Data == nil {
+		return key, false
+	}
+
+	var src, dst []byte
+	var transport TransportLayer
+
+	switch ip := pkt.Data.LinkData().(type) {
+	case *IPv4Packet:
+		if ip.FragmentOffset != 0 {
+			return key, false
+		}
+		src, dst, transport = ip.SourceAddress, ip.DestAddress, ip.InternetData()
+	case *IPv6Packet:
+		src, dst, transport = ip.SourceAddress, ip.DestinationAddress, ip.InternetData()
+	default:
+		return key, false
+	}
+
+	// The ports have to have been captured for there to be a flow.
+	switch t := transport.(type) {
+	case *TCPSegment:
+		if t.portsMissing {
+			return key, false
+		}
+		key.Protocol, key.SourcePort, key.DestinationPort = IPP_TCP, t.SourcePort, t.DestinationPort
+	case *UDPDatagram:
+		if t.portsMissing {
+			return key, false
+		}
+		key.Protocol, key.SourcePort, key.DestinationPort = IPP_UDP, t.SourcePort, t.DestinationPort
+	default:
+		return key, false
+	}
+
+	key.SourceAddress, _ = netip.AddrFromSlice(src)
+	key.DestinationAddress, _ = netip.AddrFromSlice(dst)
+	return key, true
+}
+
+// Reverse returns the FlowKey for the opposite direction of the flow.
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{
+		Protocol:           k.Protocol,
+		SourceAddress:      k.DestinationAddress,
+		DestinationAddress: k.SourceAddress,
+		SourcePort:         k.DestinationPort,
+		DestinationPort:    k.SourcePort,
+	}
+}
+
+// Conversation returns a FlowKey identifying the conversation the flow belongs to, which is the same
+// for both directions. It is whichever of the key and its reverse has the lower source address and
+// port.
+func (k FlowKey) Conversation() FlowKey {
+	order := k.SourceAddress.Compare(k.DestinationAddress)
+	if order > 0 || (order == 0 && k.SourcePort > k.DestinationPort) {
+		return k.Reverse()
+	}
+	return k
+}
+
+// String formats the FlowKey as, for example, "TCP 10.0.0.1:1234 -> 10.0.0.2:80".
+func (k FlowKey) String() string {
+	protocol := fmt.Sprintf("IP protocol %d", k.Protocol)
+	switch k.Protocol {
+	case IPP_TCP:
+		protocol = "TCP"
+	case IPP_UDP:
+		protocol = "UDP"
+	}
+
+	src := netip.AddrPortFrom(k.SourceAddress, k.SourcePort)
+	dst := netip.AddrPortFrom(k.DestinationAddress, k.DestinationPort)
+	return fmt.Sprintf("%v %v -> %v", protocol, src, dst)
+}
@@ -0,0 +1,69 @@
+package gopcap
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPacketFlow(t *testing.T) {
+	frame, _ := parseLinkData(ngTestFrame, ETHERNET)
+	key, ok := PacketFlow(Packet{Data: frame})
+
+	expected := FlowKey{
+		Protocol:           IPP_TCP,
+		SourceAddress:      netip.MustParseAddr("192.168.1.2"),
+		DestinationAddress: netip.MustParseAddr("212.204.214.114"),
+		SourcePort:         2848,
+		DestinationPort:    6667,
+	}
+	if !ok || key != expected {
+		t.Errorf("Unexpected flow: expected %v, got %v (%v).", expected, key, ok)
+	}
+	if key.String() != "TCP 192.168.1.2:2848 -> 212.204.214.114:6667" {
+		t.Errorf("Unexpected string: %v", key.String())
+	}
+
+	// Both directions belong to the same conversation.
+	if key.Conversation() != expected || key.Reverse().Conversation() != expected {
+		t.Errorf("Unexpected conversation: expected %v, got %v and %v.", expected, key.Conversation(), key.Reverse().Conversation())
+	}
+
+	// A non-IP frame has no flow.
+	_, ok = PacketFlow(Packet{Data: new(UnknownLink)})
+	if ok {
+		t.Errorf("Unexpected flow for an unknown link layer.")
+	}
+	_, ok = PacketFlow(Packet{})
+	if ok {
+		t.Errorf("Unexpected flow for an empty packet.")
+	}
+}
+
+// A capture that stopped partway through the TCP header still has a flow if it got the ports.
+func TestPacketFlowTruncated(t *testing.T) {
+	frame, _ := parseLinkData(ngTestFrame[:34+6], ETHERNET)
+	key, ok := PacketFlow(Packet{Data: frame})
+	if !ok || key.SourcePort != 2848 || key.DestinationPort != 6667 {
+		t.Errorf("Unexpected flow: %v (%v).", key, ok)
+	}
+
+	frame, _ = parseLinkData(ngTestFrame[:34+3], ETHERNET)
+	_, ok = PacketFlow(Packet{Data: frame})
+	if ok {
+		t.Errorf("Unexpected flow for a segment without its ports.")
+	}
+}
+
+func TestPacketFlowFragment(t *testing.T) {
+	data := append([]byte{}, ngTestFrame...)
+
+	// Make the packet a later fragment.
+	data[20] = 0x00
+	data[21] = 0x10
+
+	frame, _ := parseLinkData(data, ETHERNET)
+	_, ok := PacketFlow(Packet{Data: frame})
+	if ok {
+		t.Errorf("Unexpected flow for a fragment.")
+	}
+}
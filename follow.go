@@ -0,0 +1,59 @@
+package gopcap
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// FollowOptions configures a Reader created by NewFollowReader.
+type FollowOptions struct {
+	PollInterval time.Duration // How often to check for more data at the end of the file. Defaults to 250ms.
+}
+
+// followSource wraps a file that is still being written, turning io.EOF into a wait for more data.
+type followSource struct {
+	ctx  context.Context
+	src  io.Reader
+	poll time.Duration
+}
+
+// NewFollowReader creates a Reader for a .pcap file that is still being written, like tail -f.
+// Whenever the Reader reaches the end of the file, it waits for more data to be written instead of
+// returning io.EOF, checking for it every PollInterval. A record that has only been partly written is
+// waited for in the same way, so it is never reported as an error. Both creating the Reader and
+// reading packets from it wait until ctx is done, at which point they return ctx.Err(). Once that
+// has happened the Reader should no longer be used, as it may have stopped partway through a record.
+func NewFollowReader(ctx context.Context, src io.Reader, opts FollowOptions) (*Reader, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 250 * time.Millisecond
+	}
+
+	return NewReader(&followSource{ctx: ctx, src: src, poll: opts.PollInterval})
+}
+
+// Read reads from the underlying file, waiting for more data if there is none yet. It only returns
+// an error if reading fails, or if the context is done.
+func (f *followSource) Read(p []byte) (int, error) {
+	for {
+		err := f.ctx.Err()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := f.src.Read(p)
+		if n > 0 && err == io.EOF {
+			return n, nil
+		} else if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		timer := time.NewTimer(f.poll)
+		select {
+		case <-f.ctx.Done():
+			timer.Stop()
+			return 0, f.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
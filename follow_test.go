@@ -0,0 +1,99 @@
+package gopcap
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowReader(t *testing.T) {
+	file, _ := recoverTestFile()
+	recordLen := 16 + len(ngTestFrame)
+
+	// Start with the header and the first record and a half.
+	name := filepath.Join(t.TempDir(), "growing.pcap")
+	err := os.WriteFile(name, file[:24+recordLen+recordLen/2], 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	src, _ := os.Open(name)
+	defer src.Close()
+	dst, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	defer dst.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r, err := NewFollowReader(ctx, src, FollowOptions{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	pkt, err := r.Next()
+	if err != nil || pkt.IncludedLen != uint32(len(ngTestFrame)) {
+		t.Fatalf("Unexpected result: packet %+v, error %v.", pkt, err)
+	}
+
+	// The second record is only half written, so reading it has to wait for the rest.
+	packets := make(chan Packet)
+	errs := make(chan error)
+	go func() {
+		for {
+			pkt, err := r.Next()
+			if err != nil {
+				errs <- err
+				return
+			}
+			packets <- pkt
+		}
+	}()
+
+	select {
+	case pkt := <-packets:
+		t.Fatalf("Unexpected packet: %+v", pkt)
+	case err := <-errs:
+		t.Fatalf("Unexpected error: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	dst.Write(file[24+recordLen+recordLen/2 : 24+3*recordLen])
+
+	for i := 1; i < 3; i++ {
+		select {
+		case pkt := <-packets:
+			if pkt.Timestamp != 1415620800*time.Second+time.Duration(i)*time.Second {
+				t.Errorf("Unexpected TS: %v", pkt.Timestamp)
+			}
+		case err := <-errs:
+			t.Fatalf("Unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for packet %v.", i)
+		}
+	}
+
+	// Cancelling stops the waiting.
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("Unexpected error: expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for cancellation.")
+	}
+}
+
+// Creating a Reader waits for the file header to be written.
+func TestFollowReaderHeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := NewFollowReader(ctx, bytes.NewReader([]byte{0xd4, 0xc3}), FollowOptions{PollInterval: time.Millisecond})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
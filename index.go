@@ -0,0 +1,302 @@
+package gopcap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// Errors
+var NotAnIndexFile error = errors.New("Not a gopcap index file.")
+var PacketOutOfRange error = errors.New("Packet index out of range.")
+var IndexMismatch error = errors.New("Index doesn't match the pcap file.")
+
+// The magic number and version at the start of a persisted index.
+var indexMagic = []byte{'G', 'P', 'I', 'X'}
+
+const indexVersion uint16 = 3
+
+// IndexEntry records where a single packet record lives in a .pcap file, along with enough of its
+// header to answer simple queries without reading the file.
+type IndexEntry struct {
+	Offset      int64 // The offset of the record header from the start of the file
+	Timestamp   time.Duration
+	IncludedLen uint32
+	ActualLen   uint32
+}
+
+// Index is a list of the packet records in a .pcap file, allowing packets to be read in any order.
+// An Index can be saved alongside the file it describes with WriteTo and loaded with ReadIndex,
+// avoiding the need to rescan the file.
+type Index struct {
+	LinkType     Link
+	TSResolution time.Duration
+	TZCorrection int32 // In seconds, added to local time to get UTC, e.g. -3600 for GMT+1
+	MaxLen       uint32
+	Size         int64 // The number of bytes of the file covered by the index
+	Entries      []IndexEntry
+	flipped      bool
+}
+
+// IndexedReader provides random access to the packets in a .pcap file using an Index.
+type IndexedReader struct {
+	Index *Index
+	src   io.ReaderAt
+}
+
+// BuildIndex scans a .pcap file and records the location of every packet in it. Only the record
+// headers are parsed, so this is much faster than parsing the whole file. If the file ends partway
+// through a record, the Index of all the complete records is returned along with UnexpectedEOF.
+func BuildIndex(src io.ReaderAt) (*Index, error) {
+	index := &Index{Entries: make([]IndexEntry, 0)}
+	buffered := bufio.NewReader(io.NewSectionReader(src, 0, 1<<63-1))
+
+	_, flipped, resolution, err := checkMagicNum(buffered)
+	if err != nil {
+		return index, err
+	}
+	index.flipped = flipped
+	index.TSResolution = resolution
+
+	file := new(PcapFile)
+	err = populateFileHeader(file, buffered, flipped)
+	if err != nil {
+		return index, err
+	}
+	index.LinkType = file.LinkType
+	index.TZCorrection = file.TZCorrection
+	index.MaxLen = file.MaxLen
+	index.Size = 24
+
+	for {
+		pkt := new(Packet)
+		err = populatePacketHeader(pkt, buffered, flipped, resolution)
+		if err == io.EOF {
+			return index, nil
+		} else if err == InsufficientLength {
+			return index, UnexpectedEOF
+		} else if err != nil {
+			return index, err
+		}
+
+		// Skip over the packet data.
+		skipped, err := buffered.Discard(int(pkt.IncludedLen))
+		if skipped != int(pkt.IncludedLen) {
+			return index, UnexpectedEOF
+		} else if err != nil {
+			return index, err
+		}
+
+		index.Entries = append(index.Entries, IndexEntry{
+			Offset:      index.Size,
+			Timestamp:   pkt.Timestamp,
+			IncludedLen: pkt.IncludedLen,
+			ActualLen:   pkt.ActualLen,
+		})
+		index.Size += 16 + int64(pkt.IncludedLen)
+	}
+}
+
+// ReadIndex loads an Index previously saved with WriteTo.
+func ReadIndex(src io.Reader) (*Index, error) {
+	index := new(Index)
+
+	header := make([]byte, 40)
+	_, err := io.ReadFull(src, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return index, NotAnIndexFile
+	} else if err != nil {
+		return index, err
+	}
+
+	if bytes.Compare(header[0:4], indexMagic) != 0 || getUint16(header[4:6], false) != indexVersion {
+		return index, NotAnIndexFile
+	}
+
+	index.flipped = (getUint16(header[6:8], false) & 0x01) != 0
+	index.LinkType = Link(getUint32(header[8:12], false))
+	index.TSResolution = time.Duration(getUint32(header[12:16], false))
+	index.TZCorrection = int32(getUint32(header[16:20], false))
+	index.MaxLen = getUint32(header[20:24], false)
+	index.Size = int64(getUint64(header[24:32], false))
+	count := getUint64(header[32:40], false)
+
+	// Read the entries one at a time, so that a corrupt count can't make us allocate
+	// enormous amounts of memory up front.
+	index.Entries = make([]IndexEntry, 0)
+	entry := make([]byte, 24)
+
+	for i := uint64(0); i < count; i++ {
+		_, err = io.ReadFull(src, entry)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return index, UnexpectedEOF
+		} else if err != nil {
+			return index, err
+		}
+
+		index.Entries = append(index.Entries, IndexEntry{
+			Offset:      int64(getUint64(entry[0:8], false)),
+			Timestamp:   time.Duration(getUint64(entry[8:16], false)),
+			IncludedLen: getUint32(entry[16:20], false),
+			ActualLen:   getUint32(entry[20:24], false),
+		})
+	}
+
+	return index, nil
+}
+
+// WriteTo saves the Index so that it can later be loaded with ReadIndex. It implements the
+// io.WriterTo interface.
+func (i *Index) WriteTo(dst io.Writer) (int64, error) {
+	buffer := make([]byte, 40+24*len(i.Entries))
+
+	flags := uint16(0)
+	if i.flipped {
+		flags |= 0x01
+	}
+
+	copy(buffer[0:4], indexMagic)
+	putUint16(buffer[4:6], indexVersion, false)
+	putUint16(buffer[6:8], flags, false)
+	putUint32(buffer[8:12], uint32(i.LinkType), false)
+	putUint32(buffer[12:16], uint32(i.TSResolution), false)
+	putUint32(buffer[16:20], uint32(i.TZCorrection), false)
+	putUint32(buffer[20:24], i.MaxLen, false)
+	putUint64(buffer[24:32], uint64(i.Size), false)
+	putUint64(buffer[32:40], uint64(len(i.Entries)), false)
+
+	for n, entry := range i.Entries {
+		record := buffer[40+24*n : 40+24*(n+1)]
+		putUint64(record[0:8], uint64(entry.Offset), false)
+		putUint64(record[8:16], uint64(entry.Timestamp), false)
+		putUint32(record[16:20], entry.IncludedLen, false)
+		putUint32(record[20:24], entry.ActualLen, false)
+	}
+
+	written, err := dst.Write(buffer)
+	return int64(written), err
+}
+
+// NewIndexedReader creates an IndexedReader for a .pcap file. If index is nil, the file is scanned
+// to build one. Otherwise, the index is checked against the file: if the file's header doesn't match
+// the one the index was built from, or the file is shorter than the index says, IndexMismatch is
+// returned, as the index is probably for a different version of the file.
+func NewIndexedReader(src io.ReaderAt, index *Index) (*IndexedReader, error) {
+	var err error
+
+	if index == nil {
+		index, err = BuildIndex(src)
+	} else {
+		err = index.check(src)
+	}
+
+	return &IndexedReader{Index: index, src: src}, err
+}
+
+// check confirms that an Index describes the file in src.
+func (i *Index) check(src io.ReaderAt) error {
+	header := io.NewSectionReader(src, 0, 24)
+	_, flipped, resolution, err := checkMagicNum(header)
+	if err == InsufficientLength {
+		return IndexMismatch
+	} else if err != nil {
+		return err
+	}
+
+	file := new(PcapFile)
+	err = populateFileHeader(file, header, flipped)
+	if err == InsufficientLength {
+		return IndexMismatch
+	} else if err != nil {
+		return err
+	}
+
+	if flipped != i.flipped || resolution != i.TSResolution || file.LinkType != i.LinkType ||
+		file.TZCorrection != i.TZCorrection || file.MaxLen != i.MaxLen {
+		return IndexMismatch
+	}
+
+	// The last byte the index covers must be in the file.
+	if i.Size < 24 {
+		return IndexMismatch
+	}
+	// ReadAt can return io.EOF along with the last byte of the input, so only the count matters.
+	n, err := src.ReadAt(make([]byte, 1), i.Size-1)
+	if n == 1 {
+		return nil
+	} else if err == io.EOF || err == nil {
+		return IndexMismatch
+	}
+	return err
+}
+
+// Len returns the number of packets in the file.
+func (r *IndexedReader) Len() int {
+	return len(r.Index.Entries)
+}
+
+// PacketAt reads and parses the packet with the given index, counting from zero. An entry that
+// doesn't fit within the file, or whose length is larger than the file's MaxLen, returns
+// IndexMismatch.
+func (r *IndexedReader) PacketAt(i int) (Packet, error) {
+	if i < 0 || i >= len(r.Index.Entries) {
+		return Packet{}, PacketOutOfRange
+	}
+
+	// Don't trust an entry that wouldn't fit in the file, or that no capture would have recorded.
+	entry := r.Index.Entries[i]
+	if entry.IncludedLen > maxRecordLen(r.Index.MaxLen) || entry.Offset < 24 || entry.Offset+16+int64(entry.IncludedLen) > r.Index.Size {
+		return Packet{}, IndexMismatch
+	}
+	record := make([]byte, 16+int(entry.IncludedLen))
+	n, err := r.src.ReadAt(record, entry.Offset)
+	if n < len(record) && err == io.EOF {
+		return Packet{}, UnexpectedEOF
+	} else if n < len(record) {
+		return Packet{}, err
+	}
+
+	pkt := new(Packet)
+	err = parsePacket(pkt, bytes.NewReader(record), r.Index.flipped, r.Index.TSResolution, r.Index.LinkType)
+	pkt.Time = absoluteTime(pkt.Timestamp, r.Index.TZCorrection)
+	return *pkt, err
+}
+
+// SeekTime finds the first packet at or after the absolute time t, returning its index and the
+// parsed packet. If there is no such packet, it returns io.EOF. The search is a binary search, so it
+// assumes the packets are in timestamp order, as they are in almost all captures.
+func (r *IndexedReader) SeekTime(t time.Time) (int, Packet, error) {
+	i := r.search(t)
+
+	if i == len(r.Index.Entries) {
+		return i, Packet{}, io.EOF
+	}
+
+	pkt, err := r.PacketAt(i)
+	return i, pkt, err
+}
+
+// Range finds the packets between the absolute times start (inclusive) and end (exclusive). It
+// returns the index of the first packet in the range and the index after the last one, so the range
+// is empty if they are equal. As with SeekTime, the packets must be in timestamp order.
+func (r *IndexedReader) Range(start time.Time, end time.Time) (int, int) {
+	first := r.search(start)
+	last := r.search(end)
+
+	if last < first {
+		last = first
+	}
+	return first, last
+}
+
+// search returns the index of the first packet at or after the absolute time t.
+func (r *IndexedReader) search(t time.Time) int {
+	ts := fileTimestamp(t, r.Index.TZCorrection)
+
+	return sort.Search(len(r.Index.Entries), func(n int) bool {
+		return r.Index.Entries[n].Timestamp >= ts
+	})
+}
@@ -0,0 +1,245 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIndexedReader(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	r, err := NewIndexedReader(src, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.Len() != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, r.Len())
+	}
+	if r.Index.LinkType != ETHERNET {
+		t.Errorf("Incorrect link type: expected %v, got %v.", ETHERNET, r.Index.LinkType)
+	}
+	if r.Index.Size != 420869 {
+		t.Errorf("Unexpected index size: expected %v, got %v.", 420869, r.Index.Size)
+	}
+
+	// Compare some random packets with the ones found by parsing the whole file.
+	src.Seek(0, io.SeekStart)
+	parsed, _ := Parse(src)
+
+	for _, i := range []int{0, 1000, 12, 2262} {
+		pkt, err := r.PacketAt(i)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if pkt.Timestamp != parsed.Packets[i].Timestamp || pkt.IncludedLen != parsed.Packets[i].IncludedLen {
+			t.Errorf("Unexpected packet %v: expected %+v, got %+v.", i, parsed.Packets[i], pkt)
+		}
+		if _, ok := pkt.Data.(*EthernetFrame); !ok {
+			t.Errorf("Unexpected link layer: %v", pkt.Data)
+		}
+	}
+
+	_, err = r.PacketAt(2263)
+	if err != PacketOutOfRange {
+		t.Errorf("Unexpected error: expected %v, got %v", PacketOutOfRange, err)
+	}
+}
+
+func TestIndexedReaderSeekTime(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	r, err := NewIndexedReader(src, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Seeking to the exact time of a packet should find the first packet with that time.
+	target := r.Index.Entries[500].Timestamp
+	i, pkt, err := r.SeekTime(time.Unix(0, int64(target)))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if i > 500 || pkt.Timestamp != target || (i > 0 && r.Index.Entries[i-1].Timestamp >= target) {
+		t.Errorf("Unexpected seek result: index %v, TS %v.", i, pkt.Timestamp)
+	}
+
+	// Seeking to before the first packet finds the first packet.
+	i, _, err = r.SeekTime(time.Unix(0, 0))
+	if i != 0 || err != nil {
+		t.Errorf("Unexpected seek result: index %v, error %v.", i, err)
+	}
+
+	// Seeking past the end finds nothing.
+	end := r.Index.Entries[2262].Timestamp
+	_, _, err = r.SeekTime(time.Unix(0, int64(end+time.Second)))
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+	}
+
+	// A range covering everything should find every packet, and an inverted range none.
+	first, last := r.Range(time.Unix(0, 0), time.Unix(0, int64(end+time.Second)))
+	if first != 0 || last != 2263 {
+		t.Errorf("Unexpected range: expected [%v, %v), got [%v, %v).", 0, 2263, first, last)
+	}
+	first, last = r.Range(time.Unix(0, int64(end)), time.Unix(0, 0))
+	if first != last {
+		t.Errorf("Unexpected range: expected an empty range, got [%v, %v).", first, last)
+	}
+}
+
+// Seeking should work in absolute time, even if the file records local time. A TZCorrection of -3600
+// is GMT+1.
+func TestIndexedReaderTZCorrection(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, PcapFile{TZCorrection: -3600, LinkType: ETHERNET}, true)
+	for i := 0; i < 10; i++ {
+		w.WritePacket(Packet{Timestamp: time.Duration(i) * time.Hour}, ngTestFrame)
+	}
+
+	r, err := NewIndexedReader(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Local 05:00 is 04:00 UTC.
+	i, pkt, err := r.SeekTime(time.Date(1970, 1, 1, 4, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if i != 5 || pkt.Timestamp != 5*time.Hour {
+		t.Errorf("Unexpected seek result: index %v, TS %v.", i, pkt.Timestamp)
+	}
+	if !pkt.Time.Equal(time.Date(1970, 1, 1, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time: %v", pkt.Time)
+	}
+}
+
+func TestIndexPersistence(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	index, err := BuildIndex(src)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = index.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded, err := ReadIndex(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loaded.LinkType != index.LinkType || loaded.TSResolution != index.TSResolution || loaded.TZCorrection != index.TZCorrection ||
+		loaded.MaxLen != index.MaxLen || loaded.Size != index.Size || loaded.flipped != index.flipped {
+		t.Errorf("Unexpected index header: expected %+v, got %+v.", index, loaded)
+	}
+	if len(loaded.Entries) != len(index.Entries) {
+		t.Fatalf("Unexpected number of entries: expected %v, got %v.", len(index.Entries), len(loaded.Entries))
+	}
+	for i := range index.Entries {
+		if loaded.Entries[i] != index.Entries[i] {
+			t.Errorf("Unexpected entry: expected %+v, got %+v.", index.Entries[i], loaded.Entries[i])
+		}
+	}
+
+	// The loaded index should be usable straight away.
+	r, _ := NewIndexedReader(src, loaded)
+	pkt, err := r.PacketAt(100)
+	if err != nil || pkt.IncludedLen != index.Entries[100].IncludedLen {
+		t.Errorf("Unexpected packet: %+v, error %v.", pkt, err)
+	}
+
+	_, err = ReadIndex(bytes.NewReader([]byte("not an index")))
+	if err != NotAnIndexFile {
+		t.Errorf("Unexpected error: expected %v, got %v", NotAnIndexFile, err)
+	}
+}
+
+// An index for a different version of the file is rejected, as are entries that can't be right.
+// eofReaderAt returns io.EOF with any read that reaches the end of the input, as io.ReaderAt allows.
+type eofReaderAt struct {
+	r *bytes.Reader
+}
+
+func (e eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := e.r.ReadAt(p, off)
+	if err == nil && off+int64(n) == e.r.Size() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestIndexMismatch(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	index, _ := BuildIndex(bytes.NewReader(original))
+
+	_, err = NewIndexedReader(bytes.NewReader(original[:len(original)-1]), index)
+	if err != IndexMismatch {
+		t.Errorf("Unexpected error: expected %v, got %v", IndexMismatch, err)
+	}
+
+	other := new(bytes.Buffer)
+	NewWriter(other, PcapFile{LinkType: RAW}, true)
+	_, err = NewIndexedReader(bytes.NewReader(other.Bytes()), index)
+	if err != IndexMismatch {
+		t.Errorf("Unexpected error: expected %v, got %v", IndexMismatch, err)
+	}
+
+	// A ReaderAt may return io.EOF along with the last byte.
+	eofReader, err := NewIndexedReader(eofReaderAt{bytes.NewReader(original)}, index)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = eofReader.PacketAt(eofReader.Len() - 1); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	r, err := NewIndexedReader(bytes.NewReader(original), index)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	index.Entries[0].IncludedLen = 0xFFFFFFFF
+	index.Entries[1].Offset = index.Size
+	for i := 0; i < 2; i++ {
+		_, err = r.PacketAt(i)
+		if err != IndexMismatch {
+			t.Errorf("Unexpected error: expected %v, got %v", IndexMismatch, err)
+		}
+	}
+}
+
+func TestBuildIndexTruncated(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	index, err := BuildIndex(bytes.NewReader(original[:len(original)-10]))
+	if err != UnexpectedEOF {
+		t.Errorf("Unexpected error: expected %v, got %v", UnexpectedEOF, err)
+	}
+	if len(index.Entries) != 2262 {
+		t.Errorf("Unexpected number of entries: expected %v, got %v.", 2262, len(index.Entries))
+	}
+}
@@ -20,6 +20,10 @@ func (u *UnknownINet) FromBytes(data []byte) error {
 	return nil
 }
 
+func (u *UnknownINet) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	return appendLayer(b, u.data, opts)
+}
+
 //-------------------------------------------------------------------------------------------
 // IPv4
 //-------------------------------------------------------------------------------------------
@@ -41,6 +45,10 @@ type IPv4Packet struct {
 	SourceAddress  []byte
 	DestAddress    []byte
 	Options        []byte
+	Truncated      bool   // Set if the capture didn't include the whole packet
+	MissingBytes   uint32 // The number of bytes of the packet that weren't captured
+	header         []byte // The raw header, for verifying the checksum
+	payload        []byte // The raw payload, for reassembling fragments
 	data           TransportLayer
 }
 
@@ -79,11 +87,8 @@ func (p *IPv4Packet) FromBytes(data []byte) error {
 
 	// Back to the crazy with the flags: the top three bits of the 7th byte. We only care
 	// about bits two and three. It hurt me to write that sentence.
-	if (uint8(data[6]) & 0x40) != 0 {
-		p.DontFragment = true
-	} else if (uint8(data[6]) & 0x20) != 0 {
-		p.MoreFragments = true
-	}
+	p.DontFragment = (uint8(data[6]) & 0x40) != 0
+	p.MoreFragments = (uint8(data[6]) & 0x20) != 0
 
 	// Following from the flag crazy, the fragment offset is the low 13 bits of the 7th
 	// and 8th bytes.
@@ -102,34 +107,149 @@ func (p *IPv4Packet) FromBytes(data []byte) error {
 	p.SourceAddress = data[12:16]
 	p.DestAddress = data[16:20]
 
+	// The header length is measured in 32-bit words, for no good reason. It can't be shorter than
+	// the fixed header we've just parsed, or longer than the whole packet.
+	headerLen := int(p.IHL) * 4
+	if headerLen < 20 || int(p.TotalLength) < headerLen {
+		return IncorrectPacket
+	}
+
+	// If the capture stopped partway through the options, keep the ones we have. There's no
+	// transport data at all.
+	if len(data) < headerLen {
+		p.Options = data[20:]
+		p.Truncated = true
+		p.MissingBytes = uint32(int(p.TotalLength) - len(data))
+		p.data = new(UnknownTransport)
+		return nil
+	}
+
 	// If IHL is more than 5, we have (IHL - 5) * 4 bytes of options.
 	if p.IHL > 5 {
-		optionLength := uint16(p.IHL-5) * 4
-		p.Options = data[20 : 20+optionLength]
-
-		// Reslice data so that the actual packet contents still start at offset 20.
-		data = data[optionLength:]
+		p.Options = data[20:headerLen]
 	}
+	p.header = data[:headerLen]
 
-	// The data length is the total length, minus the headers. The headers are, for no good
-	// reason, measured in 32-bit words, so the data length is actually:
-	dataLen := p.TotalLength - (uint16(p.IHL) * 4)
+	// The data length is the total length, minus the headers. If the capture didn't include all of
+	// it, decode what we have and remember how much is missing.
+	data = data[headerLen:]
+	dataLen := int(p.TotalLength) - headerLen
 
-	if dataLen > uint16(len(data[20:])) {
-		return IncorrectPacket
+	if dataLen > len(data) {
+		p.Truncated = true
+		p.MissingBytes = uint32(dataLen - len(data))
+		dataLen = len(data)
 	}
 
 	// Build the transport layer data.
-	p.buildTransportLayer(data[20 : 20+dataLen])
+	p.payload = data[:dataLen]
+	p.buildTransportLayer(p.payload)
+
+	// The transport checksum covers the whole of the original datagram, so it can only be verified if
+	// this packet isn't a fragment.
+	if !p.MoreFragments && p.FragmentOffset == 0 {
+		setPseudoHeader(p.data, pseudoHeaderSum(p.SourceAddress, p.DestAddress, p.Protocol, int(p.TotalLength)-headerLen), 4)
+	}
+
+	if p.Truncated {
+		markTruncated(p.data, p.MissingBytes)
+	}
 
 	return nil
 }
 
+// VerifyChecksum checks the header checksum against the bytes the packet was decoded from.
+func (p *IPv4Packet) VerifyChecksum() ChecksumStatus {
+	if p.header == nil && p.Truncated {
+		return CHECKSUM_TRUNCATED
+	} else if p.header == nil {
+		return CHECKSUM_UNKNOWN
+	} else if foldChecksum(sumBytes(p.header, 0)) == 0 {
+		return CHECKSUM_VALID
+	} else if p.Checksum == 0 {
+		return CHECKSUM_NOT_COMPUTED
+	}
+	return CHECKSUM_INVALID
+}
+
+// AppendBytes appends the packet, along with the transport layer it contains, to b.
+func (p *IPv4Packet) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	if len(p.SourceAddress) != 4 || len(p.DestAddress) != 4 {
+		return b, InvalidField
+	}
+
+	options := p.Options
+	if opts.FixLengths {
+		options = padOptions(options)
+		if len(options) > 40 {
+			return b, InvalidField
+		}
+		p.IHL = uint8(5 + len(options)/4)
+	}
+
+	start := len(b)
+	headerLen := 20 + len(options)
+	b = append(b, make([]byte, 20)...)
+	header := b[start:]
+
+	// The same crazy non-aligned fields as in FromBytes, in reverse.
+	header[0] = (4 << 4) | (p.IHL & 0x0F)
+	header[1] = (p.DSCP << 2) | (p.ECN & 0x03)
+	putUint16(header[4:6], p.ID, false)
+
+	flags := p.FragmentOffset & 0x1FFF
+	if p.DontFragment {
+		flags |= 0x4000
+	}
+	if p.MoreFragments {
+		flags |= 0x2000
+	}
+	putUint16(header[6:8], flags, false)
+
+	header[8] = p.TTL
+	header[9] = uint8(p.Protocol)
+	copy(header[12:16], p.SourceAddress)
+	copy(header[16:20], p.DestAddress)
+	b = append(b, options...)
+
+	b, err := appendLayer(b, p.data, opts)
+	if err != nil {
+		return b, err
+	}
+
+	// Appending may have moved the buffer, so find the header again.
+	header = b[start : start+headerLen]
+
+	if opts.FixLengths {
+		if len(b)-start > 0xFFFF {
+			return b, InvalidField
+		}
+		p.TotalLength = uint16(len(b) - start)
+	}
+	putUint16(header[2:4], p.TotalLength, false)
+
+	if opts.ComputeChecksums {
+		// As when decoding, a fragment only holds part of the datagram the checksum covers.
+		if !p.Truncated && !p.MoreFragments && p.FragmentOffset == 0 {
+			segment := b[start+headerLen:]
+			setTransportChecksum(p.data, segment, pseudoHeaderSum(p.SourceAddress, p.DestAddress, p.Protocol, len(segment)))
+		}
+		putUint16(header[10:12], 0, false)
+		p.Checksum = foldChecksum(sumBytes(header, 0))
+	}
+	putUint16(header[10:12], p.Checksum, false)
+
+	return b, nil
+}
+
 func (p *IPv4Packet) buildTransportLayer(data []byte) {
-	switch p.Protocol {
-	case IPP_TCP:
+	// Only the first fragment of a fragmented packet starts with the transport header.
+	switch {
+	case p.FragmentOffset != 0:
+		p.data = new(UnknownTransport)
+	case p.Protocol == IPP_TCP:
 		p.data = new(TCPSegment)
-	case IPP_UDP:
+	case p.Protocol == IPP_UDP:
 		p.data = new(UDPDatagram)
 	default:
 		p.data = new(UnknownTransport)
@@ -149,6 +269,12 @@ type IPv6Packet struct {
 	HopLimit           uint8
 	SourceAddress      []byte
 	DestinationAddress []byte
+	ExtensionHeaders   []IPv6ExtensionHeader // In the order they appear in the packet
+	Truncated          bool                  // Set if the capture didn't include the whole packet
+	MissingBytes       uint32                // The number of bytes of the packet that weren't captured
+	raw                []byte                // The raw packet, for reassembling fragments
+	fragment           int                   // Where the Fragment header starts in raw, or 0 if there isn't one
+	fragmentNext       int                   // Where the Next Header field that points to the Fragment header is in raw
 	data               TransportLayer
 }
 
@@ -156,6 +282,15 @@ func (p *IPv6Packet) InternetData() TransportLayer {
 	return p.data
 }
 
+// UpperLayerProtocol returns the protocol of the data that follows the extension headers. If there
+// are no extension headers, this is the same as NextHeader.
+func (p *IPv6Packet) UpperLayerProtocol() IPProtocol {
+	if len(p.ExtensionHeaders) == 0 {
+		return p.NextHeader
+	}
+	return p.ExtensionHeaders[len(p.ExtensionHeaders)-1].Next()
+}
+
 func (p *IPv6Packet) FromBytes(data []byte) error {
 	// Confirm that we have enough data for the smallest possible header.
 	if len(data) < 40 {
@@ -169,7 +304,7 @@ func (p *IPv6Packet) FromBytes(data []byte) error {
 
 	// The traffic class is the octet following the version.
 	p.TrafficClass = (uint8(data[0]) & 0x0F) << 4
-	p.TrafficClass += (uint8(data[1]) * 0xF0) >> 4
+	p.TrafficClass += (uint8(data[1]) & 0xF0) >> 4
 
 	// The flow label is the next 20 bits.
 	p.FlowLabel = (uint32(data[1]) & 0x0F) << 16
@@ -184,27 +319,475 @@ func (p *IPv6Packet) FromBytes(data []byte) error {
 	p.SourceAddress = data[8:24]
 	p.DestinationAddress = data[24:40]
 
-	if p.Length > uint16(len(data[40:])) {
-		return IncorrectPacket
+	// If the capture didn't include the whole payload, decode what we have and remember how much
+	// is missing.
+	dataLen := int(p.Length)
+
+	if dataLen > len(data)-40 {
+		p.Truncated = true
+		p.MissingBytes = uint32(dataLen - (len(data) - 40))
+		dataLen = len(data) - 40
 	}
+	p.raw = data[:40+dataLen]
+
 	// Following the fixed headers are a sequence of extension headers
 	// terminating in the transport data.
-	p.parseRemainingHeaders(data[40 : 40+p.Length])
+	p.parseRemainingHeaders(data[40 : 40+dataLen])
+
+	if p.Truncated {
+		markTruncated(p.data, p.MissingBytes)
+	}
 
 	return nil
 }
 
+// AppendBytes appends the packet, along with its extension headers and the transport layer it
+// contains, to b.
+func (p *IPv6Packet) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	if len(p.SourceAddress) != 16 || len(p.DestinationAddress) != 16 {
+		return b, InvalidField
+	}
+
+	start := len(b)
+	b = append(b, make([]byte, 40)...)
+	header := b[start:]
+
+	header[0] = (6 << 4) | (p.TrafficClass >> 4)
+	header[1] = (p.TrafficClass << 4) | uint8(p.FlowLabel>>16)&0x0F
+	header[2] = uint8(p.FlowLabel >> 8)
+	header[3] = uint8(p.FlowLabel)
+	header[6] = uint8(p.NextHeader)
+	header[7] = p.HopLimit
+	copy(header[8:24], p.SourceAddress)
+	copy(header[24:40], p.DestinationAddress)
+
+	var err error
+	for _, ext := range p.ExtensionHeaders {
+		b, err = ext.AppendBytes(b, opts)
+		if err != nil {
+			return b, err
+		}
+	}
+	transportStart := len(b)
+
+	b, err = appendLayer(b, p.data, opts)
+	if err != nil {
+		return b, err
+	}
+
+	// Appending may have moved the buffer, so find the header again.
+	header = b[start : start+40]
+	payload := b[start+40:]
+
+	if opts.FixLengths {
+		if len(payload) > 0xFFFF {
+			return b, InvalidField
+		}
+		p.Length = uint16(len(payload))
+	}
+	putUint16(header[4:6], p.Length, false)
+
+	if opts.ComputeChecksums && !p.Truncated && p.transportVerifiable() {
+		segment := b[transportStart:]
+		setTransportChecksum(p.data, segment, pseudoHeaderSum(p.SourceAddress, p.DestinationAddress, p.UpperLayerProtocol(), len(segment)))
+	}
+
+	return b, nil
+}
+
+// parseRemainingHeaders walks the chain of extension headers that follows the fixed header, and then
+// decodes the upper-layer protocol at the end of it. The walk stops at the first header that gopcap
+// doesn't understand, or that wasn't captured in full, and everything from there on is treated as
+// unknown transport data.
 func (p *IPv6Packet) parseRemainingHeaders(data []byte) {
-	// Currently we don't support any extension headers so if the next header
-	// isn't the transport data then give up and interpret it as an unknown
-	// transport type.
-	switch p.NextHeader {
-	case IPP_TCP:
+	p.ExtensionHeaders = nil
+	p.fragment, p.fragmentNext = 0, 6
+	next := p.NextHeader
+	extLen := 0
+
+	laterFragment := false
+
+	for {
+		ext := newIPv6ExtensionHeader(next)
+		n := ipv6ExtensionHeaderLength(next, data)
+		if ext == nil || n < 0 || n > len(data) || ext.FromBytes(data[:n]) != nil {
+			break
+		}
+
+		if h, ok := ext.(*IPv6Fragment); ok {
+			laterFragment = h.FragmentOffset != 0
+			if p.fragment == 0 {
+				p.fragment = 40 + extLen
+			}
+		}
+		if p.fragment == 0 {
+			p.fragmentNext = 40 + extLen
+		}
+
+		p.ExtensionHeaders = append(p.ExtensionHeaders, ext)
+		next = ext.Next()
+		data = data[n:]
+		extLen += n
+
+		// The headers after the Fragment header are only in the first fragment.
+		if laterFragment {
+			break
+		}
+	}
+
+	// Only the first fragment of a fragmented packet starts with the transport header.
+	switch {
+	case laterFragment:
+		p.data = new(UnknownTransport)
+	case next == IPP_TCP:
 		p.data = new(TCPSegment)
-	case IPP_UDP:
+	case next == IPP_UDP:
 		p.data = new(UDPDatagram)
 	default:
 		p.data = new(UnknownTransport)
 	}
 	p.data.FromBytes(data)
+
+	if p.transportVerifiable() {
+		setPseudoHeader(p.data, pseudoHeaderSum(p.SourceAddress, p.DestinationAddress, next, int(p.Length)-extLen), 6)
+	}
+}
+
+// transportVerifiable reports whether the transport checksum can be computed from this packet: it must
+// hold the whole upper-layer packet, and be addressed to its final destination.
+func (p *IPv6Packet) transportVerifiable() bool {
+	for _, ext := range p.ExtensionHeaders {
+		switch h := ext.(type) {
+		case *IPv6Fragment:
+			if h.FragmentOffset != 0 || h.MoreFragments {
+				return false
+			}
+		case *IPv6Routing:
+			if h.SegmentsLeft != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//-------------------------------------------------------------------------------------------
+// IPv6 extension headers
+//-------------------------------------------------------------------------------------------
+
+// IPv6ExtensionHeader is one of the extension headers that can come between the fixed IPv6 header
+// and the upper-layer protocol. HeaderType returns the protocol number that identifies the kind of
+// header, and Next returns the type of whatever follows it.
+type IPv6ExtensionHeader interface {
+	HeaderType() IPProtocol
+	Next() IPProtocol
+	FromBytes(data []byte) error
+	AppendBytes(b []byte, opts SerializeOptions) ([]byte, error)
+}
+
+// newIPv6ExtensionHeader returns an empty extension header of the given type, or nil if the type
+// isn't an extension header that gopcap understands.
+func newIPv6ExtensionHeader(kind IPProtocol) IPv6ExtensionHeader {
+	switch kind {
+	case IPP_HOPOPT:
+		return new(IPv6HopByHop)
+	case IPP_IPV6_ROUTE:
+		return new(IPv6Routing)
+	case IPP_IPV6_FRAG:
+		return new(IPv6Fragment)
+	case IPP_AH:
+		return new(IPv6Authentication)
+	case IPP_IPV6_OPTS:
+		return new(IPv6DestinationOptions)
+	}
+	return nil
+}
+
+// ipv6ExtensionHeaderLength returns the length of the extension header at the start of data, or -1
+// if there isn't enough data to tell. Most extension headers give their length in 8-byte units, not
+// counting the first 8 bytes, but the Authentication Header uses 4-byte units, not counting the
+// first 8, and the Fragment header is always 8 bytes long.
+func ipv6ExtensionHeaderLength(kind IPProtocol, data []byte) int {
+	if len(data) < 8 {
+		return -1
+	}
+
+	switch kind {
+	case IPP_IPV6_FRAG:
+		return 8
+	case IPP_AH:
+		return (int(data[1]) + 2) * 4
+	}
+	return (int(data[1]) + 1) * 8
+}
+
+// appendIPv6ExtensionLength fills in the length field of an extension header that has just been
+// appended to b, starting at start. The header must be a multiple of unit bytes long, and base is
+// the number of units that the length field doesn't count.
+func appendIPv6ExtensionLength(b []byte, start int, unit int, base int) ([]byte, error) {
+	length := len(b) - start
+	if length%unit != 0 || length/unit-base > 0xFF {
+		return b, InvalidField
+	}
+
+	b[start+1] = uint8(length/unit - base)
+	return b, nil
+}
+
+// IPv6Option is a single option from a Hop-by-Hop or Destination Options header. Padding is kept as
+// options too, so that the header can be written back out exactly: Pad1 has type 0 and no data, and
+// PadN has type 1.
+type IPv6Option struct {
+	Type uint8
+	Data []byte
+}
+
+// parseIPv6Options decodes the options in a Hop-by-Hop or Destination Options header.
+func parseIPv6Options(data []byte) ([]IPv6Option, error) {
+	options := []IPv6Option{}
+
+	for len(data) > 0 {
+		// Pad1 is a single byte, with no length.
+		if data[0] == 0 {
+			options = append(options, IPv6Option{})
+			data = data[1:]
+			continue
+		}
+
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return options, InsufficientLength
+		}
+		options = append(options, IPv6Option{Type: data[0], Data: data[2 : 2+int(data[1])]})
+		data = data[2+int(data[1]):]
+	}
+
+	return options, nil
+}
+
+// appendIPv6Options appends a Hop-by-Hop or Destination Options header to b. If opts.FixLengths is
+// set, padding is added to make it a multiple of 8 bytes long.
+func appendIPv6Options(b []byte, next IPProtocol, options []IPv6Option, opts SerializeOptions) ([]byte, error) {
+	start := len(b)
+	b = append(b, uint8(next), 0)
+
+	for _, option := range options {
+		if option.Type == 0 {
+			b = append(b, 0)
+			continue
+		}
+		if len(option.Data) > 0xFF {
+			return b, InvalidField
+		}
+		b = append(b, option.Type, uint8(len(option.Data)))
+		b = append(b, option.Data...)
+	}
+
+	if opts.FixLengths {
+		switch pad := (8 - (len(b)-start)%8) % 8; pad {
+		case 0:
+		case 1:
+			b = append(b, 0)
+		default:
+			b = append(b, 1, uint8(pad-2))
+			b = append(b, make([]byte, pad-2)...)
+		}
+	}
+
+	return appendIPv6ExtensionLength(b, start, 8, 1)
+}
+
+// IPv6HopByHop is a Hop-by-Hop Options header, holding options for every node along the path.
+type IPv6HopByHop struct {
+	NextHeader IPProtocol
+	Options    []IPv6Option
+}
+
+func (h *IPv6HopByHop) HeaderType() IPProtocol {
+	return IPP_HOPOPT
+}
+
+func (h *IPv6HopByHop) Next() IPProtocol {
+	return h.NextHeader
+}
+
+func (h *IPv6HopByHop) FromBytes(data []byte) error {
+	if len(data) < 8 {
+		return InsufficientLength
+	}
+
+	var err error
+	h.NextHeader = IPProtocol(data[0])
+	h.Options, err = parseIPv6Options(data[2:])
+	return err
+}
+
+func (h *IPv6HopByHop) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	return appendIPv6Options(b, h.NextHeader, h.Options, opts)
+}
+
+// IPv6DestinationOptions is a Destination Options header, holding options for the destination. It
+// can come either before a Routing header, for each of the nodes the packet is routed through, or at
+// the end of the chain, for the final destination only.
+type IPv6DestinationOptions struct {
+	NextHeader IPProtocol
+	Options    []IPv6Option
+}
+
+func (h *IPv6DestinationOptions) HeaderType() IPProtocol {
+	return IPP_IPV6_OPTS
+}
+
+func (h *IPv6DestinationOptions) Next() IPProtocol {
+	return h.NextHeader
+}
+
+func (h *IPv6DestinationOptions) FromBytes(data []byte) error {
+	if len(data) < 8 {
+		return InsufficientLength
+	}
+
+	var err error
+	h.NextHeader = IPProtocol(data[0])
+	h.Options, err = parseIPv6Options(data[2:])
+	return err
+}
+
+func (h *IPv6DestinationOptions) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	return appendIPv6Options(b, h.NextHeader, h.Options, opts)
+}
+
+// IPv6Routing is a Routing header, listing nodes the packet must pass through on the way to its
+// destination. The layout of the list depends on the routing type, so it is left as raw data.
+type IPv6Routing struct {
+	NextHeader   IPProtocol
+	RoutingType  uint8
+	SegmentsLeft uint8
+	Data         []byte // The type-specific data that follows the first four bytes
+}
+
+func (h *IPv6Routing) HeaderType() IPProtocol {
+	return IPP_IPV6_ROUTE
+}
+
+func (h *IPv6Routing) Next() IPProtocol {
+	return h.NextHeader
+}
+
+func (h *IPv6Routing) FromBytes(data []byte) error {
+	if len(data) < 8 {
+		return InsufficientLength
+	}
+
+	h.NextHeader = IPProtocol(data[0])
+	h.RoutingType = data[2]
+	h.SegmentsLeft = data[3]
+	h.Data = data[4:]
+	return nil
+}
+
+// AppendBytes appends the header to b. If opts.FixLengths is set, the data is padded with zeros to
+// make the header a multiple of 8 bytes long.
+func (h *IPv6Routing) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	start := len(b)
+	b = append(b, uint8(h.NextHeader), 0, h.RoutingType, h.SegmentsLeft)
+	b = append(b, h.Data...)
+
+	if opts.FixLengths {
+		b = append(b, make([]byte, (8-(len(b)-start)%8)%8)...)
+	}
+
+	return appendIPv6ExtensionLength(b, start, 8, 1)
+}
+
+// IPv6Fragment is a Fragment header, found in each of the fragments of a packet that was too big to
+// send whole. As in IPv4, the offset is measured in units of 8 bytes.
+type IPv6Fragment struct {
+	NextHeader     IPProtocol
+	FragmentOffset uint16
+	MoreFragments  bool
+	ID             uint32
+}
+
+func (h *IPv6Fragment) HeaderType() IPProtocol {
+	return IPP_IPV6_FRAG
+}
+
+func (h *IPv6Fragment) Next() IPProtocol {
+	return h.NextHeader
+}
+
+func (h *IPv6Fragment) FromBytes(data []byte) error {
+	if len(data) < 8 {
+		return InsufficientLength
+	}
+
+	// The offset is the top 13 bits of the third and fourth bytes, and the More Fragments flag is
+	// the bottom bit.
+	h.NextHeader = IPProtocol(data[0])
+	h.FragmentOffset = getUint16(data[2:4], false) >> 3
+	h.MoreFragments = (data[3] & 0x01) != 0
+	h.ID = getUint32(data[4:8], false)
+	return nil
+}
+
+func (h *IPv6Fragment) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	start := len(b)
+	b = append(b, make([]byte, 8)...)
+	header := b[start:]
+
+	flags := h.FragmentOffset << 3
+	if h.MoreFragments {
+		flags |= 0x01
+	}
+
+	header[0] = uint8(h.NextHeader)
+	putUint16(header[2:4], flags, false)
+	putUint32(header[4:8], h.ID, false)
+	return b, nil
+}
+
+// IPv6Authentication is an Authentication Header (RFC 4302), which carries an integrity check value
+// for the packet.
+type IPv6Authentication struct {
+	NextHeader     IPProtocol
+	SPI            uint32
+	SequenceNumber uint32
+	ICV            []byte // The integrity check value, including any padding
+}
+
+func (h *IPv6Authentication) HeaderType() IPProtocol {
+	return IPP_AH
+}
+
+func (h *IPv6Authentication) Next() IPProtocol {
+	return h.NextHeader
+}
+
+func (h *IPv6Authentication) FromBytes(data []byte) error {
+	if len(data) < 12 {
+		return InsufficientLength
+	}
+
+	h.NextHeader = IPProtocol(data[0])
+	h.SPI = getUint32(data[4:8], false)
+	h.SequenceNumber = getUint32(data[8:12], false)
+	h.ICV = data[12:]
+	return nil
+}
+
+// AppendBytes appends the header to b. If opts.FixLengths is set, the ICV is padded with zeros to
+// make the header a multiple of 8 bytes long, as IPv6 requires.
+func (h *IPv6Authentication) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	start := len(b)
+	b = append(b, uint8(h.NextHeader), 0, 0, 0)
+	b = append(b, make([]byte, 8)...)
+	putUint32(b[start+4:start+8], h.SPI, false)
+	putUint32(b[start+8:start+12], h.SequenceNumber, false)
+	b = append(b, h.ICV...)
+
+	if opts.FixLengths {
+		b = append(b, make([]byte, (8-(len(b)-start)%8)%8)...)
+	}
+
+	return appendIPv6ExtensionLength(b, start, 4, 2)
 }
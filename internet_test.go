@@ -44,6 +44,25 @@ func TestIPv6(t *testing.T) {
 
 }
 
+// The traffic class straddles the first two bytes, so both halves must be masked out correctly.
+func TestIPv6TrafficClass(t *testing.T) {
+	dataStr := "6ab12345002411403ffe050700000001020086fffe0580da3ffe0501481900000000000000000042095c00350024f0090006010000010000000000000669746f6a756e036f72670000ff0001"
+	data, _ := hex.DecodeString(dataStr)
+
+	pkt := new(IPv6Packet)
+	err := pkt.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if pkt.TrafficClass != 0xAB {
+		t.Errorf("Unexpected traffic class: expected %v, got %v", 0xAB, pkt.TrafficClass)
+	}
+	if pkt.FlowLabel != 0x12345 {
+		t.Errorf("Unexpected flow label: expected %v, got %v", 0x12345, pkt.FlowLabel)
+	}
+}
+
 // Special case of the Telnet packet padded with zeros at Ethernet Frame level
 func TestIPv4EthernetPaddedPacket(t *testing.T) {
 
@@ -121,3 +140,223 @@ func TestIPv4Good(t *testing.T) {
 		t.Errorf("Shouldn't have any options: got %v", pkt.Options)
 	}
 }
+
+// A packet cut short by the capture's snapshot length should still be decoded as far as possible.
+func TestIPv4Truncated(t *testing.T) {
+	// Keep the IP header, the TCP header and five bytes of the payload.
+	data := ngTestFrame[14 : 14+20+32+5]
+
+	pkt := new(IPv4Packet)
+	err := pkt.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !pkt.Truncated {
+		t.Errorf("Expected packet to be truncated and it wasn't.")
+	}
+	if pkt.MissingBytes != 25 {
+		t.Errorf("Unexpected missing bytes: expected %v, got %v", 25, pkt.MissingBytes)
+	}
+
+	tcp, ok := pkt.InternetData().(*TCPSegment)
+	if !ok {
+		t.Fatalf("Unexpected transport layer: %v", pkt.InternetData())
+	}
+	if tcp.SourcePort != 2848 || tcp.DestinationPort != 6667 {
+		t.Errorf("Unexpected ports: expected %v and %v, got %v and %v", 2848, 6667, tcp.SourcePort, tcp.DestinationPort)
+	}
+	if !tcp.Truncated || tcp.MissingBytes != 25 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 25, tcp.MissingBytes, tcp.Truncated)
+	}
+	if len(tcp.TransportData()) != 5 {
+		t.Errorf("Unexpected length of transport data: expected %v, got %v", 5, len(tcp.TransportData()))
+	}
+}
+
+func TestIPv4TruncatedOptions(t *testing.T) {
+	// An IPv4 header claiming two words of options, of which only one was captured.
+	data := []byte{
+		0x47, 0x00, 0x00, 0x30, 0x00, 0x00, 0x00, 0x00, 0x40, 0x06, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01,
+		0x0a, 0x00, 0x00, 0x02, 0x01, 0x01, 0x01, 0x01,
+	}
+
+	pkt := new(IPv4Packet)
+	err := pkt.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !pkt.Truncated || pkt.MissingBytes != 24 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 24, pkt.MissingBytes, pkt.Truncated)
+	}
+	if len(pkt.Options) != 4 {
+		t.Errorf("Unexpected option length: expected %v, got %v", 4, len(pkt.Options))
+	}
+	if len(pkt.InternetData().TransportData()) != 0 {
+		t.Errorf("Unexpected transport data: %v", pkt.InternetData().TransportData())
+	}
+}
+
+func TestIPv6Truncated(t *testing.T) {
+	dataStr := "0060970769ea0000860580da86dd60000000002411403ffe050700000001020086fffe0580da3ffe0501481900000000000000000042095c00350024f0090006010000010000000000000669746f6a756e036f72670000ff0001"
+
+	// Keep the IP header, the UDP header and four bytes of the payload.
+	data, _ := hex.DecodeString(dataStr[28 : 28+2*(40+8+4)])
+
+	pkt := new(IPv6Packet)
+	err := pkt.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !pkt.Truncated || pkt.MissingBytes != 24 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 24, pkt.MissingBytes, pkt.Truncated)
+	}
+
+	udp, ok := pkt.InternetData().(*UDPDatagram)
+	if !ok {
+		t.Fatalf("Unexpected transport layer: %v", pkt.InternetData())
+	}
+	if udp.DestinationPort != 53 {
+		t.Errorf("Unexpected destination port: expected %v, got %v", 53, udp.DestinationPort)
+	}
+	if !udp.Truncated || udp.MissingBytes != 24 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 24, udp.MissingBytes, udp.Truncated)
+	}
+	if len(udp.TransportData()) != 4 {
+		t.Errorf("Unexpected length of transport data: expected %v, got %v", 4, len(udp.TransportData()))
+	}
+}
+
+// ipv6ExtensionTestPacket builds an IPv6 UDP packet with the given extension headers, the first of
+// which has type first.
+func ipv6ExtensionTestPacket(first IPProtocol, headers ...IPv6ExtensionHeader) []byte {
+	data, _ := NewPacketBuilder().
+		IPv6(&IPv6Packet{NextHeader: first, SourceAddress: make([]byte, 16), DestinationAddress: make([]byte, 16), ExtensionHeaders: headers}).
+		UDP(&UDPDatagram{SourcePort: 547, DestinationPort: 547}).
+		Payload([]byte("solicit")).
+		Build()
+	return data
+}
+
+func TestIPv6ExtensionHeaders(t *testing.T) {
+	// A Router Alert option, and then a Destination Options header that needs padding.
+	data := ipv6ExtensionTestPacket(IPP_HOPOPT,
+		&IPv6HopByHop{NextHeader: IPP_IPV6_OPTS, Options: []IPv6Option{{Type: 5, Data: []byte{0, 0}}, {Type: 1, Data: []byte{}}}},
+		&IPv6DestinationOptions{NextHeader: IPP_UDP, Options: []IPv6Option{{Type: 0xC9, Data: []byte{1, 2, 3}}}},
+	)
+	if len(data) != 40+8+8+8+7 {
+		t.Fatalf("Unexpected length: expected %v, got %v.", 40+8+8+8+7, len(data))
+	}
+
+	pkt := new(IPv6Packet)
+	err := pkt.FromBytes(data)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(pkt.ExtensionHeaders) != 2 || pkt.UpperLayerProtocol() != IPP_UDP {
+		t.Fatalf("Unexpected extension headers: %v", pkt.ExtensionHeaders)
+	}
+
+	hop, ok := pkt.ExtensionHeaders[0].(*IPv6HopByHop)
+	if !ok || hop.NextHeader != IPP_IPV6_OPTS || len(hop.Options) != 2 || hop.Options[0].Type != 5 || hop.Options[1].Type != 1 {
+		t.Errorf("Unexpected Hop-by-Hop header: %+v", pkt.ExtensionHeaders[0])
+	}
+
+	// The padding added while building the packet shows up as options.
+	dest, ok := pkt.ExtensionHeaders[1].(*IPv6DestinationOptions)
+	if !ok || len(dest.Options) != 2 || bytes.Compare(dest.Options[0].Data, []byte{1, 2, 3}) != 0 || dest.Options[1].Type != 0 {
+		t.Errorf("Unexpected Destination Options header: %+v", pkt.ExtensionHeaders[1])
+	}
+
+	udp, ok := pkt.InternetData().(*UDPDatagram)
+	if !ok || string(udp.TransportData()) != "solicit" {
+		t.Fatalf("Unexpected transport layer: %+v", pkt.InternetData())
+	}
+	if udp.VerifyChecksum() != CHECKSUM_VALID {
+		t.Errorf("Unexpected UDP checksum status: %v", udp.VerifyChecksum())
+	}
+
+	// It should serialize back to the same bytes.
+	out, err := ToBytes(pkt, SerializeOptions{})
+	if err != nil || bytes.Compare(out, data) != 0 {
+		t.Errorf("Unexpected bytes: expected %x, got %x (%v).", data, out, err)
+	}
+}
+
+func TestIPv6FragmentHeader(t *testing.T) {
+	// The first fragment has the UDP header, but not the whole datagram.
+	data := ipv6ExtensionTestPacket(IPP_IPV6_FRAG, &IPv6Fragment{NextHeader: IPP_UDP, MoreFragments: true, ID: 0xDEADBEEF})
+	pkt := new(IPv6Packet)
+	pkt.FromBytes(data)
+
+	frag, ok := pkt.ExtensionHeaders[0].(*IPv6Fragment)
+	if !ok || !frag.MoreFragments || frag.FragmentOffset != 0 || frag.ID != 0xDEADBEEF {
+		t.Errorf("Unexpected Fragment header: %+v", pkt.ExtensionHeaders[0])
+	}
+	udp, ok := pkt.InternetData().(*UDPDatagram)
+	if !ok || udp.SourcePort != 547 {
+		t.Fatalf("Unexpected transport layer: %+v", pkt.InternetData())
+	}
+	if udp.VerifyChecksum() != CHECKSUM_UNKNOWN {
+		t.Errorf("Unexpected UDP checksum status: %v", udp.VerifyChecksum())
+	}
+
+	// Later fragments don't.
+	data = ipv6ExtensionTestPacket(IPP_IPV6_FRAG, &IPv6Fragment{NextHeader: IPP_UDP, FragmentOffset: 185})
+	pkt.FromBytes(data)
+	if pkt.ExtensionHeaders[0].(*IPv6Fragment).FragmentOffset != 185 {
+		t.Errorf("Unexpected Fragment header: %+v", pkt.ExtensionHeaders[0])
+	}
+	if _, ok := pkt.InternetData().(*UnknownTransport); !ok {
+		t.Errorf("Unexpected transport layer: %+v", pkt.InternetData())
+	}
+}
+
+func TestIPv6RoutingHeader(t *testing.T) {
+	// With segments left, the destination address isn't the one the checksum was computed with.
+	data := ipv6ExtensionTestPacket(IPP_IPV6_ROUTE,
+		&IPv6Routing{NextHeader: IPP_AH, RoutingType: 4, SegmentsLeft: 1, Data: make([]byte, 20)},
+		&IPv6Authentication{NextHeader: IPP_UDP, SPI: 0x100, SequenceNumber: 7, ICV: make([]byte, 12)},
+	)
+	pkt := new(IPv6Packet)
+	pkt.FromBytes(data)
+
+	if len(pkt.ExtensionHeaders) != 2 {
+		t.Fatalf("Unexpected extension headers: %v", pkt.ExtensionHeaders)
+	}
+	routing, ok := pkt.ExtensionHeaders[0].(*IPv6Routing)
+	if !ok || routing.RoutingType != 4 || routing.SegmentsLeft != 1 || len(routing.Data) != 20 {
+		t.Errorf("Unexpected Routing header: %+v", pkt.ExtensionHeaders[0])
+	}
+	ah, ok := pkt.ExtensionHeaders[1].(*IPv6Authentication)
+	if !ok || ah.SPI != 0x100 || ah.SequenceNumber != 7 || len(ah.ICV) != 12 || data[65] != 4 {
+		t.Errorf("Unexpected Authentication Header: %+v", pkt.ExtensionHeaders[1])
+	}
+	if pkt.InternetData().(*UDPDatagram).VerifyChecksum() != CHECKSUM_UNKNOWN {
+		t.Errorf("Unexpected UDP checksum status: %v", pkt.InternetData().(*UDPDatagram).VerifyChecksum())
+	}
+}
+
+// The walk stops at headers it doesn't understand, and at headers that weren't captured in full.
+func TestIPv6ExtensionHeadersStop(t *testing.T) {
+	data := ipv6ExtensionTestPacket(IPP_ESP)
+	pkt := new(IPv6Packet)
+	pkt.FromBytes(data)
+	if len(pkt.ExtensionHeaders) != 0 || pkt.UpperLayerProtocol() != IPP_ESP {
+		t.Errorf("Unexpected extension headers: %v", pkt.ExtensionHeaders)
+	}
+	if _, ok := pkt.InternetData().(*UnknownTransport); !ok {
+		t.Errorf("Unexpected transport layer: %+v", pkt.InternetData())
+	}
+
+	data = ipv6ExtensionTestPacket(IPP_HOPOPT, &IPv6HopByHop{NextHeader: IPP_UDP, Options: []IPv6Option{{Type: 1, Data: make([]byte, 12)}}})
+	pkt.FromBytes(data[:50])
+	if len(pkt.ExtensionHeaders) != 0 || !pkt.Truncated {
+		t.Errorf("Unexpected extension headers: %v", pkt.ExtensionHeaders)
+	}
+	if len(pkt.InternetData().TransportData()) != 10 {
+		t.Errorf("Unexpected transport data: %v", pkt.InternetData().TransportData())
+	}
+}
@@ -24,6 +24,10 @@ func (u *UnknownLink) FromBytes(data []byte) error {
 	return err
 }
 
+func (u *UnknownLink) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	return appendLayer(b, u.data, opts)
+}
+
 //-------------------------------------------------------------------------------------------
 // EthernetFrame
 //-------------------------------------------------------------------------------------------
@@ -72,6 +76,38 @@ func (e *EthernetFrame) FromBytes(data []byte) error {
 	return nil
 }
 
+// AppendBytes appends the frame, along with the internet layer it contains, to b. If FixLengths is
+// set and the frame has a length rather than an EtherType, the length is recomputed.
+func (e *EthernetFrame) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	if len(e.MACDestination) != 6 || len(e.MACSource) != 6 || (e.VLANTag != nil && len(e.VLANTag) != 4) {
+		return b, InvalidField
+	}
+
+	b = append(b, e.MACDestination...)
+	b = append(b, e.MACSource...)
+	b = append(b, e.VLANTag...)
+
+	// The length or EtherType goes here, once we know the length.
+	start := len(b)
+	b = append(b, 0, 0)
+
+	b, err := appendLayer(b, e.data, opts)
+	if err != nil {
+		return b, err
+	}
+
+	if e.EtherType != 0 {
+		putUint16(b[start:start+2], uint16(e.EtherType), false)
+	} else {
+		if opts.FixLengths {
+			e.Length = uint16(len(b) - start - 2)
+		}
+		putUint16(b[start:start+2], e.Length, false)
+	}
+
+	return b, nil
+}
+
 // buildInternetLayer creates the internet layer sub-data for a link layer datagram.
 func (e *EthernetFrame) buildInternetLayer(data []byte) {
 	switch e.EtherType {
@@ -0,0 +1,290 @@
+package gopcap
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors
+var MismatchedLinkTypes error = errors.New("Sources have different link types.")
+
+// MergeSource is a capture that can be merged: either a *Reader or an *NgReader.
+type MergeSource interface {
+	// readMergeRecord reads the next packet record, along with the interface it was captured on.
+	readMergeRecord() (NgPacket, []byte, mergeInterface, error)
+
+	// mergeInterfaces returns the interfaces the source is known to have so far.
+	mergeInterfaces() []mergeInterface
+}
+
+// mergeInterface is a capture interface of a merge source. key tells the interfaces of a source
+// apart, and maxLen is the largest packet the interface can have captured.
+type mergeInterface struct {
+	key     interface{}
+	iface   NgInterface
+	maxLen  uint32
+	flipped bool
+}
+
+// ngMergeKey identifies an interface of an NgReader. Interface IDs start again in each section.
+type ngMergeKey struct {
+	section *NgSection
+	id      uint32
+}
+
+func (r *Reader) readMergeRecord() (NgPacket, []byte, mergeInterface, error) {
+	pkt, data, err := r.ReadRecord()
+	return NgPacket{Packet: pkt}, data, r.mergeInterfaces()[0], err
+}
+
+func (r *Reader) mergeInterfaces() []mergeInterface {
+	iface := NgInterface{LinkType: r.LinkType, SnapLen: r.MaxLen, TSResol: 6}
+	if r.TSResolution == time.Nanosecond {
+		iface.TSResol = 9
+	}
+	return []mergeInterface{{iface: iface, maxLen: r.MaxLen, flipped: r.flipped}}
+}
+
+func (r *NgReader) readMergeRecord() (NgPacket, []byte, mergeInterface, error) {
+	pkt, data, iface, err := r.readRecord()
+	if err != nil {
+		return pkt, data, mergeInterface{}, err
+	}
+	return pkt, data, r.mergeInterface(pkt.InterfaceID, *iface), nil
+}
+
+func (r *NgReader) mergeInterfaces() []mergeInterface {
+	interfaces := make([]mergeInterface, 0, len(r.Section.Interfaces))
+	for id, iface := range r.Section.Interfaces {
+		if iface.validTSResol() {
+			interfaces = append(interfaces, r.mergeInterface(uint32(id), iface))
+		}
+	}
+	return interfaces
+}
+
+func (r *NgReader) mergeInterface(id uint32, iface NgInterface) mergeInterface {
+	iface.Statistics = nil
+	m := mergeInterface{key: ngMergeKey{section: r.Section, id: id}, iface: iface, maxLen: iface.SnapLen, flipped: r.flipped}
+
+	// A zero snapshot length means there's no limit, so use libpcap's largest.
+	if m.maxLen == 0 {
+		m.maxLen = 262144
+	}
+	return m
+}
+
+// Merge combines the packets from several captures into one pcap file, written to dst in timestamp
+// order. The sources are read a packet at a time, so only one packet from each is held in memory.
+// Packets are ordered by their absolute Time, and packets with the same time are written in the
+// order of their sources. The captures must all have the same link type: if they don't, nothing is
+// written and MismatchedLinkTypes is returned, and MergeNg should be used instead. The interfaces of
+// a pcapng source are only known once its first packet has been read, so if one of them turns out
+// to have a different link type later on, merging stops there and MismatchedLinkTypes is returned.
+//
+// The output uses the byte order of the first source, and nanosecond timestamps if any source has
+// timestamps finer than microseconds. A source that ends partway through a packet, as a capture
+// that was cut short does, ends there, and the rest of the sources are still merged: UnexpectedEOF
+// is returned once they have been. If a source returns any other error, merging stops and the error
+// is returned.
+func Merge(dst io.Writer, sources []MergeSource) error {
+	var w *Writer
+	header := PcapFile{TSResolution: time.Microsecond}
+
+	return merge(sources, func(interfaces [][]mergeInterface) error {
+		flipped := true
+		first := true
+		for _, source := range interfaces {
+			for _, m := range source {
+				if first {
+					header.LinkType, flipped, first = m.iface.LinkType, m.flipped, false
+				} else if m.iface.LinkType != header.LinkType {
+					return MismatchedLinkTypes
+				}
+
+				if m.maxLen > header.MaxLen {
+					header.MaxLen = m.maxLen
+				}
+				if m.iface.finerThanMicroseconds() {
+					header.TSResolution = time.Nanosecond
+				}
+			}
+		}
+
+		var err error
+		w, err = NewWriter(dst, header, flipped)
+		return err
+	}, func(source int, m mergeInterface, pkt NgPacket, data []byte) error {
+		if m.iface.LinkType != header.LinkType {
+			return MismatchedLinkTypes
+		}
+		return w.WritePacket(pkt.Packet, data)
+	})
+}
+
+// MergeNg combines the packets from several captures into one pcapng file, written to dst in
+// timestamp order as for Merge. Each interface of each source becomes an interface in the output, so
+// the sources can have different link types. The interfaces of pcapng sources keep their names,
+// comments and timestamp resolutions, and their packets keep their comments and flags.
+func MergeNg(dst io.Writer, sources []MergeSource) error {
+	var w *NgWriter
+	type outputKey struct {
+		source int
+		key    interface{}
+	}
+	ids := make(map[outputKey]uint32)
+
+	addInterface := func(source int, m mergeInterface) (uint32, error) {
+		key := outputKey{source: source, key: m.key}
+		id, ok := ids[key]
+		if !ok {
+			var err error
+			id, err = w.AddInterface(m.iface)
+			if err != nil {
+				return 0, err
+			}
+			ids[key] = id
+		}
+		return id, nil
+	}
+
+	return merge(sources, func(interfaces [][]mergeInterface) error {
+		flipped := true
+		for _, source := range interfaces {
+			if len(source) > 0 {
+				flipped = source[0].flipped
+				break
+			}
+		}
+
+		var err error
+		w, err = NewNgWriter(dst, NgSection{UserApplication: "gopcap"}, flipped)
+		if err != nil {
+			return err
+		}
+
+		// The interfaces that are already known are added in source order. Any others are added when
+		// their first packet is written.
+		for source, known := range interfaces {
+			for _, m := range known {
+				_, err = addInterface(source, m)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, func(source int, m mergeInterface, pkt NgPacket, data []byte) error {
+		id, err := addInterface(source, m)
+		if err != nil {
+			return err
+		}
+		pkt.InterfaceID = id
+		return w.WritePacket(pkt, data)
+	})
+}
+
+// merge reads packet records from each of the sources, passing them to write in timestamp order.
+// Once the first record of each source has been read, start is called with the interfaces each
+// source is known to have. A source that ends with a truncated record is treated
+// as having ended, and UnexpectedEOF is returned after everything else has been written.
+func merge(sources []MergeSource, start func(interfaces [][]mergeInterface) error, write func(source int, m mergeInterface, pkt NgPacket, data []byte) error) error {
+	queue := make(mergeQueue, 0, len(sources))
+	var truncated error
+
+	// ended reports whether a source has no more records, noting whether it was cut short.
+	ended := func(err error) bool {
+		if err == UnexpectedEOF {
+			truncated = err
+		}
+		return err == io.EOF || err == UnexpectedEOF
+	}
+
+	for i := range sources {
+		record, err := readMergeRecord(sources[i], i)
+		if ended(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		queue = append(queue, record)
+	}
+	heap.Init(&queue)
+
+	interfaces := make([][]mergeInterface, len(sources))
+	for i, source := range sources {
+		interfaces[i] = source.mergeInterfaces()
+	}
+	err := start(interfaces)
+	if err != nil {
+		return err
+	}
+
+	for len(queue) > 0 {
+		record := queue[0]
+
+		err := write(record.source, record.iface, record.pkt, record.data)
+		if err != nil {
+			return err
+		}
+
+		// Replace the record we've written with the next one from the same source.
+		next, err := readMergeRecord(sources[record.source], record.source)
+		if ended(err) {
+			heap.Pop(&queue)
+			continue
+		} else if err != nil {
+			return err
+		}
+		queue[0] = next
+		heap.Fix(&queue, 0)
+	}
+
+	return truncated
+}
+
+// mergeRecord is a packet record waiting to be merged, tagged with the index of its source and the
+// interface it was captured on.
+type mergeRecord struct {
+	source int
+	iface  mergeInterface
+	pkt    NgPacket
+	data   []byte
+}
+
+// readMergeRecord reads the next packet record from a source.
+func readMergeRecord(r MergeSource, source int) (mergeRecord, error) {
+	pkt, data, iface, err := r.readMergeRecord()
+	return mergeRecord{source: source, iface: iface, pkt: pkt, data: data}, err
+}
+
+// mergeQueue is a heap of the next packet record from each source, earliest first.
+type mergeQueue []mergeRecord
+
+func (q mergeQueue) Len() int {
+	return len(q)
+}
+
+func (q mergeQueue) Less(i, j int) bool {
+	if q[i].pkt.Time.Equal(q[j].pkt.Time) {
+		return q[i].source < q[j].source
+	}
+	return q[i].pkt.Time.Before(q[j].pkt.Time)
+}
+
+func (q mergeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *mergeQueue) Push(x interface{}) {
+	*q = append(*q, x.(mergeRecord))
+}
+
+func (q *mergeQueue) Pop() interface{} {
+	old := *q
+	record := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return record
+}
@@ -0,0 +1,271 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// mergeTestSource builds a pcap file containing the test frame at each of the given times, and
+// returns a Reader for it.
+func mergeTestSource(t *testing.T, header PcapFile, flipped bool, times ...time.Time) *Reader {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, header, flipped)
+	for _, when := range times {
+		w.WritePacket(Packet{Time: when}, ngTestFrame)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return r
+}
+
+func TestMerge(t *testing.T) {
+	base := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return base.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	// The second source records local time, and has nanosecond timestamps.
+	sources := []MergeSource{
+		mergeTestSource(t, PcapFile{MaxLen: 1500, LinkType: ETHERNET}, false, at(0), at(20), at(30)),
+		mergeTestSource(t, PcapFile{MaxLen: 65535, LinkType: ETHERNET, TZCorrection: 3600, TSResolution: time.Nanosecond}, true, at(10), at(20), at(40)),
+		mergeTestSource(t, PcapFile{LinkType: ETHERNET}, true),
+	}
+
+	buf := new(bytes.Buffer)
+	err := Merge(buf, sources)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.flipped || r.MaxLen != 65535 || r.TSResolution != time.Nanosecond || r.TZCorrection != 0 {
+		t.Errorf("Unexpected header: %+v", r.header())
+	}
+
+	expected := []time.Time{at(0), at(10), at(20), at(20), at(30), at(40)}
+	packets, err := readAll(r)
+	if err != io.EOF || len(packets) != len(expected) {
+		t.Fatalf("Unexpected result: expected %v packets and %v, got %v packets and %v.", len(expected), io.EOF, len(packets), err)
+	}
+	for i, pkt := range packets {
+		if !pkt.Time.Equal(expected[i]) {
+			t.Errorf("Unexpected time for packet %v: expected %v, got %v.", i, expected[i], pkt.Time)
+		}
+	}
+}
+
+func TestMergeMismatchedLinkTypes(t *testing.T) {
+	when := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+	sources := []MergeSource{
+		mergeTestSource(t, PcapFile{LinkType: ETHERNET}, true, when),
+		mergeTestSource(t, PcapFile{LinkType: RAW}, true, when),
+	}
+
+	buf := new(bytes.Buffer)
+	err := Merge(buf, sources)
+	if err != MismatchedLinkTypes {
+		t.Errorf("Unexpected error: expected %v, got %v", MismatchedLinkTypes, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected output: %v bytes written.", buf.Len())
+	}
+}
+
+// A source cut off partway through a packet ends there, and the other sources are merged in full.
+func TestMergeTruncated(t *testing.T) {
+	base := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	truncated := new(bytes.Buffer)
+	w, _ := NewWriter(truncated, PcapFile{LinkType: ETHERNET}, true)
+	w.WritePacket(Packet{Time: base}, ngTestFrame)
+	w.WritePacket(Packet{Time: base.Add(2 * time.Second)}, ngTestFrame)
+	truncated.Truncate(truncated.Len() - 10)
+	first, _ := NewReader(truncated)
+
+	sources := []MergeSource{
+		first,
+		mergeTestSource(t, PcapFile{LinkType: ETHERNET}, true, base.Add(time.Second), base.Add(3*time.Second)),
+	}
+
+	buf := new(bytes.Buffer)
+	err := Merge(buf, sources)
+	if err != UnexpectedEOF {
+		t.Errorf("Unexpected error: expected %v, got %v", UnexpectedEOF, err)
+	}
+
+	r, _ := NewReader(buf)
+	packets, err := readAll(r)
+	if err != io.EOF || len(packets) != 3 {
+		t.Fatalf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 3, io.EOF, len(packets), err)
+	}
+	if !packets[2].Time.Equal(base.Add(3 * time.Second)) {
+		t.Errorf("Unexpected time for the last packet: %v", packets[2].Time)
+	}
+}
+
+func TestMergeNg(t *testing.T) {
+	base := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+	sources := []MergeSource{
+		mergeTestSource(t, PcapFile{MaxLen: 1500, LinkType: ETHERNET}, true, base.Add(time.Second), base.Add(3*time.Second)),
+		mergeTestSource(t, PcapFile{MaxLen: 9000, LinkType: RAW, TSResolution: time.Nanosecond}, true, base.Add(2*time.Second)),
+	}
+
+	buf := new(bytes.Buffer)
+	err := MergeNg(buf, sources)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	file, err := ParseNg(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	section := file.Sections[0]
+	if len(section.Interfaces) != 2 {
+		t.Fatalf("Unexpected number of interfaces: expected %v, got %v.", 2, len(section.Interfaces))
+	}
+	if section.Interfaces[0].LinkType != ETHERNET || section.Interfaces[0].SnapLen != 1500 || section.Interfaces[0].TSResol != 6 {
+		t.Errorf("Unexpected interface: %+v", section.Interfaces[0])
+	}
+	if section.Interfaces[1].LinkType != RAW || section.Interfaces[1].SnapLen != 9000 || section.Interfaces[1].TSResol != 9 {
+		t.Errorf("Unexpected interface: %+v", section.Interfaces[1])
+	}
+
+	expected := []uint32{0, 1, 0}
+	if len(section.Packets) != len(expected) {
+		t.Fatalf("Unexpected number of packets: expected %v, got %v.", len(expected), len(section.Packets))
+	}
+	for i, pkt := range section.Packets {
+		if pkt.InterfaceID != expected[i] {
+			t.Errorf("Unexpected interface for packet %v: expected %v, got %v.", i, expected[i], pkt.InterfaceID)
+		}
+		if !pkt.Time.Equal(base.Add(time.Duration(i+1) * time.Second)) {
+			t.Errorf("Unexpected time for packet %v: %v", i, pkt.Time)
+		}
+	}
+}
+
+// Merging a file with itself should give each packet twice. The file isn't quite in timestamp order,
+// so only the start is checked.
+func TestMergeSelf(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	first, _ := NewReader(bytes.NewReader(original))
+	second, _ := NewReader(bytes.NewReader(original))
+
+	buf := new(bytes.Buffer)
+	err = Merge(buf, []MergeSource{first, second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r, _ := NewReader(buf)
+	packets, err := readAll(r)
+	if err != io.EOF || len(packets) != 4526 {
+		t.Fatalf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 4526, io.EOF, len(packets), err)
+	}
+	for i := 0; i < 100; i += 2 {
+		if packets[i].Timestamp != packets[i+1].Timestamp {
+			t.Errorf("Unexpected packets %v and %v: %v and %v.", i, i+1, packets[i].Timestamp, packets[i+1].Timestamp)
+		}
+	}
+}
+
+// mergeTestNgSource builds a pcapng file with the given interfaces and packets, each holding the
+// test frame, and returns an NgReader for it.
+func mergeTestNgSource(t *testing.T, interfaces []NgInterface, packets ...NgPacket) *NgReader {
+	buf := new(bytes.Buffer)
+	w, _ := NewNgWriter(buf, NgSection{Interfaces: interfaces}, false)
+	for _, pkt := range packets {
+		w.WritePacket(pkt, ngTestFrame)
+	}
+
+	r, err := NewNgReader(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return r
+}
+
+// pcapng captures can be merged too, with each of their interfaces becoming one in a pcapng output.
+func TestMergeNgSources(t *testing.T) {
+	base := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+	at := func(s int, iface uint32, comments ...string) NgPacket {
+		return NgPacket{Packet: Packet{Time: base.Add(time.Duration(s) * time.Second)}, InterfaceID: iface, Comments: comments}
+	}
+	eth := NgInterface{LinkType: ETHERNET, Name: "eth1", TSResol: 9}
+	raw := NgInterface{LinkType: RAW, Name: "tun0", TSResol: 6}
+
+	// Into a pcap file, which needs a single link type.
+	buf := new(bytes.Buffer)
+	err := Merge(buf, []MergeSource{
+		mergeTestSource(t, PcapFile{MaxLen: 1500, LinkType: ETHERNET}, false, base.Add(time.Second), base.Add(4*time.Second)),
+		mergeTestNgSource(t, []NgInterface{eth}, at(2, 0), at(3, 0)),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	r, _ := NewReader(buf)
+	if r.LinkType != ETHERNET || r.MaxLen != 262144 || r.TSResolution != time.Nanosecond {
+		t.Errorf("Unexpected header: %+v", r.header())
+	}
+	packets, err := readAll(r)
+	if err != io.EOF || len(packets) != 4 {
+		t.Fatalf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 4, io.EOF, len(packets), err)
+	}
+	for i, pkt := range packets {
+		if !pkt.Time.Equal(base.Add(time.Duration(i+1) * time.Second)) {
+			t.Errorf("Unexpected time for packet %v: %v", i, pkt.Time)
+		}
+	}
+
+	buf = new(bytes.Buffer)
+	err = Merge(buf, []MergeSource{mergeTestNgSource(t, []NgInterface{eth, raw}, at(2, 0), at(3, 1))})
+	if err != MismatchedLinkTypes || buf.Len() != 0 {
+		t.Errorf("Unexpected error: expected %v, got %v", MismatchedLinkTypes, err)
+	}
+
+	// Into a pcapng file, keeping the interfaces apart.
+	buf = new(bytes.Buffer)
+	err = MergeNg(buf, []MergeSource{
+		mergeTestSource(t, PcapFile{MaxLen: 1500, LinkType: ETHERNET}, false, base.Add(time.Second), base.Add(4*time.Second)),
+		mergeTestNgSource(t, []NgInterface{eth, raw}, at(2, 0, "first"), at(3, 1), at(5, 0)),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file, err := ParseNg(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	section := file.Sections[0]
+	if len(section.Interfaces) != 3 || section.Interfaces[1].Name != "eth1" || section.Interfaces[1].TSResol != 9 || section.Interfaces[2].LinkType != RAW {
+		t.Fatalf("Unexpected interfaces: %+v", section.Interfaces)
+	}
+	expected := []uint32{0, 1, 2, 0, 1}
+	if len(section.Packets) != len(expected) {
+		t.Fatalf("Unexpected number of packets: expected %v, got %v.", len(expected), len(section.Packets))
+	}
+	for i, pkt := range section.Packets {
+		if pkt.InterfaceID != expected[i] || !pkt.Time.Equal(base.Add(time.Duration(i+1)*time.Second)) {
+			t.Errorf("Unexpected packet %v: interface %v at %v", i, pkt.InterfaceID, pkt.Time)
+		}
+	}
+	if len(section.Packets[1].Comments) != 1 || section.Packets[1].Comments[0] != "first" {
+		t.Errorf("Unexpected comments: %v", section.Packets[1].Comments)
+	}
+}
@@ -0,0 +1,680 @@
+package gopcap
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Errors
+var InvalidBlock error = errors.New("Invalid pcapng block.")
+var UnknownInterface error = errors.New("Unknown pcapng interface.")
+
+// The pcapng block types understood by gopcap. See
+// https://github.com/pcapng/pcapng for the full definitions.
+const (
+	ngBlockInterfaceDescription uint32 = 0x00000001
+	ngBlockPacket               uint32 = 0x00000002
+	ngBlockSimplePacket         uint32 = 0x00000003
+	ngBlockNameResolution       uint32 = 0x00000004
+	ngBlockInterfaceStatistics  uint32 = 0x00000005
+	ngBlockEnhancedPacket       uint32 = 0x00000006
+	ngBlockSectionHeader        uint32 = 0x0A0D0D0A
+)
+
+// The byte-order magic found in every Section Header Block.
+const ngByteOrderMagic uint32 = 0x1A2B3C4D
+
+// The largest block gopcap is prepared to read. Anything bigger is assumed to be corrupt.
+const ngMaxBlockLength uint32 = 16 * 1024 * 1024
+
+// The largest decimal if_tsresol exponent: 10^19 is the largest power of ten that fits in a uint64.
+const ngMaxTSResolExponent uint8 = 19
+
+// Option codes. Codes 0 and 1 are common to all blocks, the remainder are specific to the block
+// they appear in.
+const (
+	ngOptEndOfOpt        uint16 = 0
+	ngOptComment         uint16 = 1
+	ngOptSHBHardware     uint16 = 2
+	ngOptSHBOS           uint16 = 3
+	ngOptSHBUserAppl     uint16 = 4
+	ngOptIfName          uint16 = 2
+	ngOptIfDescription   uint16 = 3
+	ngOptIfSpeed         uint16 = 8
+	ngOptIfTSResol       uint16 = 9
+	ngOptIfOS            uint16 = 12
+	ngOptIfTSOffset      uint16 = 14
+	ngOptEPBFlags        uint16 = 2
+	ngOptEPBDropCount    uint16 = 4
+	ngOptISBStartTime    uint16 = 2
+	ngOptISBEndTime      uint16 = 3
+	ngOptISBIfRecv       uint16 = 4
+	ngOptISBIfDrop       uint16 = 5
+	ngOptISBFilterAccept uint16 = 6
+	ngOptISBOSDrop       uint16 = 7
+	ngOptISBUsrDeliv     uint16 = 8
+)
+
+// Name resolution record types.
+const (
+	ngNameRecordEnd  uint16 = 0
+	ngNameRecordIPv4 uint16 = 1
+	ngNameRecordIPv6 uint16 = 2
+)
+
+// PcapNgFile represents the parsed form of a single .pcapng file. A pcapng file is made up of one
+// or more sections, each of which has its own interfaces and packets.
+type PcapNgFile struct {
+	Sections []NgSection
+}
+
+// NgSection represents a single section of a pcapng file: the contents of a Section Header Block,
+// along with all the interfaces, name resolution records and packets that follow it.
+type NgSection struct {
+	MajorVersion    uint16
+	MinorVersion    uint16
+	Hardware        string
+	OS              string
+	UserApplication string
+	Comments        []string
+	Interfaces      []NgInterface
+	Names           []NgNameRecord
+	Packets         []NgPacket
+}
+
+// NgInterface represents a single capture interface, as described by an Interface Description
+// Block. Every packet in a pcapng file refers to the interface it was captured on.
+type NgInterface struct {
+	LinkType    Link
+	SnapLen     uint32
+	Name        string
+	Description string
+	OS          string
+	Speed       uint64 // In bits per second
+	TSResol     uint8  // The raw if_tsresol value. Read as 6, i.e. microseconds, if the option is absent.
+	TSOffset    int64  // In seconds, added to every timestamp on this interface
+	Comments    []string
+	Statistics  *NgInterfaceStatistics // The most recent statistics, if any were recorded.
+}
+
+// NgInterfaceStatistics represents the contents of an Interface Statistics Block. Counters that
+// were not present in the file are zero.
+type NgInterfaceStatistics struct {
+	Timestamp      time.Duration
+	StartTime      time.Duration
+	EndTime        time.Duration
+	Received       uint64
+	Dropped        uint64
+	FilterAccepted uint64
+	OSDropped      uint64
+	Delivered      uint64
+	Comments       []string
+}
+
+// NgNameRecord is a single entry from a Name Resolution Block, mapping an IPv4 or IPv6 address to
+// one or more names.
+type NgNameRecord struct {
+	Address []byte
+	Names   []string
+}
+
+// NgPacket is a single packet from a pcapng file. As well as the Packet itself, it carries the
+// index of the interface the packet was captured on and any per-packet options.
+type NgPacket struct {
+	Packet
+	InterfaceID uint32
+	Flags       uint32
+	DropCount   uint64
+	Comments    []string
+}
+
+// ngOption is a single, uninterpreted option from a pcapng block.
+type ngOption struct {
+	code  uint16
+	value []byte
+}
+
+// NgReader is a streaming parser for .pcapng files. Packets are read one at a time by calling Next.
+// As blocks describing the capture are encountered they are recorded on the current Section.
+type NgReader struct {
+	Section *NgSection
+	src     io.Reader
+	flipped bool
+}
+
+// ParseNg is the pcapng equivalent of Parse. It takes anything that implements the io.Reader
+// interface and attempts to parse the entire file. If an error is encountered, as much of the
+// parsed content as is possible will be returned, along with an error value.
+func ParseNg(src io.Reader) (PcapNgFile, error) {
+	file := PcapNgFile{}
+
+	r, err := NewNgReader(src)
+	if err != nil {
+		return file, err
+	}
+
+	sections := []*NgSection{r.Section}
+
+	for {
+		pkt, err := r.Next()
+
+		// A new Section Header Block starts a new section.
+		if r.Section != sections[len(sections)-1] {
+			sections = append(sections, r.Section)
+		}
+
+		if err == io.EOF {
+			err = nil
+			break
+		}
+
+		r.Section.Packets = append(r.Section.Packets, pkt)
+
+		if err != nil {
+			break
+		}
+	}
+
+	for _, section := range sections {
+		file.Sections = append(file.Sections, *section)
+	}
+
+	return file, err
+}
+
+// NewNgReader creates an NgReader from anything that implements the io.Reader interface. It reads
+// and validates the first Section Header Block before returning. As with NewReader, compressed files
+// are decompressed transparently.
+func NewNgReader(src io.Reader) (*NgReader, error) {
+	r := &NgReader{Section: new(NgSection)}
+
+	// If the file is compressed, read through a decompressor.
+	src, err := decompress(src)
+	if err != nil {
+		return r, err
+	}
+	r.src = src
+
+	// The file must start with a Section Header Block.
+	_, body, err := r.readBlock(true)
+	if err == io.EOF || err == InvalidBlock {
+		return r, NotAPcapFile
+	} else if err != nil {
+		return r, err
+	}
+
+	err = r.parseSectionHeader(body)
+	return r, err
+}
+
+// Next reads blocks from the file until it finds a packet, which it parses and returns. When there
+// are no more packets it returns io.EOF.
+func (r *NgReader) Next() (NgPacket, error) {
+	pkt, data, iface, err := r.readRecord()
+	if err != nil {
+		return pkt, err
+	}
+
+	pkt.Data, err = parseLinkData(data, iface.LinkType)
+	return pkt, err
+}
+
+// readRecord reads blocks from the file until it finds a packet, and returns it without parsing the
+// packet data, along with the raw captured bytes and the interface it was captured on.
+func (r *NgReader) readRecord() (NgPacket, []byte, *NgInterface, error) {
+	for {
+		blockType, body, err := r.readBlock(false)
+		if err != nil {
+			return NgPacket{}, nil, nil, err
+		}
+
+		switch blockType {
+		case ngBlockSectionHeader:
+			r.Section = new(NgSection)
+			err = r.parseSectionHeader(body)
+		case ngBlockInterfaceDescription:
+			err = r.parseInterfaceDescription(body)
+		case ngBlockNameResolution:
+			err = r.parseNameResolution(body)
+		case ngBlockInterfaceStatistics:
+			err = r.parseInterfaceStatistics(body)
+		case ngBlockEnhancedPacket:
+			return r.parseEnhancedPacket(body)
+		case ngBlockSimplePacket:
+			return r.parseSimplePacket(body)
+		case ngBlockPacket:
+			return r.parseObsoletePacket(body)
+		}
+
+		// Any other block types are skipped.
+		if err != nil {
+			return NgPacket{}, nil, nil, err
+		}
+	}
+}
+
+// readBlock reads the next block from the file, returning its type and body. The body excludes the
+// type and both copies of the length. Reading a Section Header Block updates the byte order. If
+// sectionOnly is set, any other type of block is rejected as invalid.
+func (r *NgReader) readBlock(sectionOnly bool) (uint32, []byte, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r.src, header)
+	if err == io.ErrUnexpectedEOF {
+		return 0, nil, UnexpectedEOF
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	// The Section Header Block type reads the same in either byte order. Its byte order magic
+	// tells us how to read everything else in the section, including its own length.
+	blockType := getUint32(header[0:4], r.flipped)
+	var magic []byte
+
+	if blockType == ngBlockSectionHeader {
+		magic = make([]byte, 4)
+		_, err = io.ReadFull(r.src, magic)
+		if err != nil {
+			return 0, nil, UnexpectedEOF
+		}
+
+		if getUint32(magic, false) == ngByteOrderMagic {
+			r.flipped = false
+		} else if getUint32(magic, true) == ngByteOrderMagic {
+			r.flipped = true
+		} else {
+			return 0, nil, InvalidBlock
+		}
+	} else if sectionOnly {
+		return 0, nil, InvalidBlock
+	}
+
+	length := getUint32(header[4:8], r.flipped)
+	if length < uint32(12+len(magic)) || length%4 != 0 || length > ngMaxBlockLength {
+		return 0, nil, InvalidBlock
+	}
+
+	rest := make([]byte, length-8)
+	copy(rest, magic)
+	_, err = io.ReadFull(r.src, rest[len(magic):])
+	if err != nil {
+		return 0, nil, UnexpectedEOF
+	}
+
+	// The length is repeated at the end of the block.
+	if getUint32(rest[len(rest)-4:], r.flipped) != length {
+		return 0, nil, InvalidBlock
+	}
+
+	return blockType, rest[:len(rest)-4], nil
+}
+
+// parseSectionHeader populates the current section from the body of a Section Header Block.
+func (r *NgReader) parseSectionHeader(body []byte) error {
+	if len(body) < 16 {
+		return InvalidBlock
+	}
+
+	// The first four bytes are the byte order magic, which has already been handled. The 64-bit
+	// section length that follows the versions is not needed when reading sequentially.
+	r.Section.MajorVersion = getUint16(body[4:6], r.flipped)
+	r.Section.MinorVersion = getUint16(body[6:8], r.flipped)
+
+	for _, opt := range parseNgOptions(body[16:], r.flipped) {
+		switch opt.code {
+		case ngOptComment:
+			r.Section.Comments = append(r.Section.Comments, string(opt.value))
+		case ngOptSHBHardware:
+			r.Section.Hardware = string(opt.value)
+		case ngOptSHBOS:
+			r.Section.OS = string(opt.value)
+		case ngOptSHBUserAppl:
+			r.Section.UserApplication = string(opt.value)
+		}
+	}
+
+	return nil
+}
+
+// parseInterfaceDescription adds an interface to the current section from the body of an Interface
+// Description Block. An interface whose timestamp resolution can't be used is still added, so that
+// the IDs of later interfaces stay right, but returns InvalidBlock.
+func (r *NgReader) parseInterfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return InvalidBlock
+	}
+
+	iface := NgInterface{
+		LinkType: Link(getUint16(body[0:2], r.flipped)),
+		SnapLen:  getUint32(body[4:8], r.flipped),
+		TSResol:  6,
+	}
+
+	for _, opt := range parseNgOptions(body[8:], r.flipped) {
+		switch {
+		case opt.code == ngOptComment:
+			iface.Comments = append(iface.Comments, string(opt.value))
+		case opt.code == ngOptIfName:
+			iface.Name = string(opt.value)
+		case opt.code == ngOptIfDescription:
+			iface.Description = string(opt.value)
+		case opt.code == ngOptIfOS:
+			iface.OS = string(opt.value)
+		case opt.code == ngOptIfSpeed && len(opt.value) >= 8:
+			iface.Speed = getUint64(opt.value, r.flipped)
+		case opt.code == ngOptIfTSResol && len(opt.value) >= 1:
+			iface.TSResol = opt.value[0]
+		case opt.code == ngOptIfTSOffset && len(opt.value) >= 8:
+			iface.TSOffset = int64(getUint64(opt.value, r.flipped))
+		}
+	}
+
+	r.Section.Interfaces = append(r.Section.Interfaces, iface)
+	if !iface.validTSResol() {
+		return InvalidBlock
+	}
+	return nil
+}
+
+// parseNameResolution adds the records from a Name Resolution Block to the current section.
+func (r *NgReader) parseNameResolution(body []byte) error {
+	for len(body) >= 4 {
+		recordType := getUint16(body[0:2], r.flipped)
+		length := int(getUint16(body[2:4], r.flipped))
+		body = body[4:]
+
+		if recordType == ngNameRecordEnd {
+			break
+		}
+		if length > len(body) {
+			return InvalidBlock
+		}
+
+		value := body[:length]
+		if ngPadded(length) > len(body) {
+			body = body[length:]
+		} else {
+			body = body[ngPadded(length):]
+		}
+
+		addrLen := 0
+		switch recordType {
+		case ngNameRecordIPv4:
+			addrLen = 4
+		case ngNameRecordIPv6:
+			addrLen = 16
+		}
+		if addrLen == 0 || len(value) < addrLen {
+			continue
+		}
+
+		record := NgNameRecord{Address: value[:addrLen], Names: splitNullTerminated(value[addrLen:])}
+		r.Section.Names = append(r.Section.Names, record)
+	}
+
+	return nil
+}
+
+// parseInterfaceStatistics records the contents of an Interface Statistics Block on the interface
+// it refers to.
+func (r *NgReader) parseInterfaceStatistics(body []byte) error {
+	if len(body) < 12 {
+		return InvalidBlock
+	}
+
+	iface, err := r.getInterface(getUint32(body[0:4], r.flipped))
+	if err != nil {
+		return err
+	}
+
+	stats := &NgInterfaceStatistics{
+		Timestamp: iface.timestamp(getUint32(body[4:8], r.flipped), getUint32(body[8:12], r.flipped)),
+	}
+
+	for _, opt := range parseNgOptions(body[12:], r.flipped) {
+		if opt.code == ngOptComment {
+			stats.Comments = append(stats.Comments, string(opt.value))
+			continue
+		}
+		if len(opt.value) < 8 {
+			continue
+		}
+
+		switch opt.code {
+		case ngOptISBStartTime:
+			stats.StartTime = iface.timestamp(getUint32(opt.value[0:4], r.flipped), getUint32(opt.value[4:8], r.flipped))
+		case ngOptISBEndTime:
+			stats.EndTime = iface.timestamp(getUint32(opt.value[0:4], r.flipped), getUint32(opt.value[4:8], r.flipped))
+		case ngOptISBIfRecv:
+			stats.Received = getUint64(opt.value, r.flipped)
+		case ngOptISBIfDrop:
+			stats.Dropped = getUint64(opt.value, r.flipped)
+		case ngOptISBFilterAccept:
+			stats.FilterAccepted = getUint64(opt.value, r.flipped)
+		case ngOptISBOSDrop:
+			stats.OSDropped = getUint64(opt.value, r.flipped)
+		case ngOptISBUsrDeliv:
+			stats.Delivered = getUint64(opt.value, r.flipped)
+		}
+	}
+
+	iface.Statistics = stats
+	return nil
+}
+
+// parseEnhancedPacket builds a packet from the body of an Enhanced Packet Block.
+func (r *NgReader) parseEnhancedPacket(body []byte) (NgPacket, []byte, *NgInterface, error) {
+	pkt := NgPacket{}
+	if len(body) < 20 {
+		return pkt, nil, nil, InvalidBlock
+	}
+
+	pkt.InterfaceID = getUint32(body[0:4], r.flipped)
+	iface, err := r.getInterface(pkt.InterfaceID)
+	if err != nil {
+		return pkt, nil, nil, err
+	}
+
+	pkt.Timestamp = iface.timestamp(getUint32(body[4:8], r.flipped), getUint32(body[8:12], r.flipped))
+	pkt.Time = absoluteTime(pkt.Timestamp, 0)
+	pkt.IncludedLen = getUint32(body[12:16], r.flipped)
+	pkt.ActualLen = getUint32(body[16:20], r.flipped)
+
+	body = body[20:]
+	if uint64(pkt.IncludedLen) > uint64(len(body)) {
+		return pkt, nil, nil, InvalidBlock
+	}
+
+	data := body[:pkt.IncludedLen]
+	for _, opt := range parseNgOptions(body[ngPadded(len(data)):], r.flipped) {
+		switch {
+		case opt.code == ngOptComment:
+			pkt.Comments = append(pkt.Comments, string(opt.value))
+		case opt.code == ngOptEPBFlags && len(opt.value) >= 4:
+			pkt.Flags = getUint32(opt.value, r.flipped)
+		case opt.code == ngOptEPBDropCount && len(opt.value) >= 8:
+			pkt.DropCount = getUint64(opt.value, r.flipped)
+		}
+	}
+
+	return pkt, data, iface, nil
+}
+
+// parseSimplePacket builds a packet from the body of a Simple Packet Block. Simple packets always
+// belong to the first interface and carry no timestamp.
+func (r *NgReader) parseSimplePacket(body []byte) (NgPacket, []byte, *NgInterface, error) {
+	pkt := NgPacket{}
+	if len(body) < 4 {
+		return pkt, nil, nil, InvalidBlock
+	}
+
+	iface, err := r.getInterface(0)
+	if err != nil {
+		return pkt, nil, nil, err
+	}
+
+	// The captured length is not recorded: it's the smallest of the original length, the
+	// snapshot length and the space available in the block.
+	pkt.ActualLen = getUint32(body[0:4], r.flipped)
+	pkt.IncludedLen = pkt.ActualLen
+	if iface.SnapLen != 0 && pkt.IncludedLen > iface.SnapLen {
+		pkt.IncludedLen = iface.SnapLen
+	}
+	if uint64(pkt.IncludedLen) > uint64(len(body)-4) {
+		pkt.IncludedLen = uint32(len(body) - 4)
+	}
+
+	return pkt, body[4 : 4+pkt.IncludedLen], iface, nil
+}
+
+// parseObsoletePacket builds a packet from the body of the obsolete Packet Block, which is still
+// written by some older tools.
+func (r *NgReader) parseObsoletePacket(body []byte) (NgPacket, []byte, *NgInterface, error) {
+	pkt := NgPacket{}
+	if len(body) < 20 {
+		return pkt, nil, nil, InvalidBlock
+	}
+
+	pkt.InterfaceID = uint32(getUint16(body[0:2], r.flipped))
+	iface, err := r.getInterface(pkt.InterfaceID)
+	if err != nil {
+		return pkt, nil, nil, err
+	}
+
+	pkt.DropCount = uint64(getUint16(body[2:4], r.flipped))
+	pkt.Timestamp = iface.timestamp(getUint32(body[4:8], r.flipped), getUint32(body[8:12], r.flipped))
+	pkt.Time = absoluteTime(pkt.Timestamp, 0)
+	pkt.IncludedLen = getUint32(body[12:16], r.flipped)
+	pkt.ActualLen = getUint32(body[16:20], r.flipped)
+
+	body = body[20:]
+	if uint64(pkt.IncludedLen) > uint64(len(body)) {
+		return pkt, nil, nil, InvalidBlock
+	}
+
+	data := body[:pkt.IncludedLen]
+	for _, opt := range parseNgOptions(body[ngPadded(len(data)):], r.flipped) {
+		if opt.code == ngOptComment {
+			pkt.Comments = append(pkt.Comments, string(opt.value))
+		}
+	}
+
+	return pkt, data, iface, nil
+}
+
+// getInterface returns the interface with the given ID in the current section. An interface whose
+// timestamp resolution can't be used returns UnsupportedResolution.
+func (r *NgReader) getInterface(id uint32) (*NgInterface, error) {
+	if uint64(id) >= uint64(len(r.Section.Interfaces)) {
+		return nil, UnknownInterface
+	}
+	iface := &r.Section.Interfaces[id]
+	if !iface.validTSResol() {
+		return nil, UnsupportedResolution
+	}
+	return iface, nil
+}
+
+// validTSResol reports whether the interface's timestamp resolution can be converted to and from
+// nanoseconds without overflowing.
+func (i *NgInterface) validTSResol() bool {
+	return i.TSResol&0x80 != 0 || i.TSResol <= ngMaxTSResolExponent
+}
+
+// finerThanMicroseconds reports whether the interface's timestamps are more precise than a
+// microsecond.
+func (i *NgInterface) finerThanMicroseconds() bool {
+	if i.TSResol&0x80 == 0 {
+		return i.TSResol > 6
+	}
+	return i.TSResol&0x7F >= 20
+}
+
+// timestamp converts the two halves of a pcapng timestamp into a time.Duration since the epoch,
+// using the resolution and offset of the interface. The resolution must be valid, which
+// parseInterfaceDescription checks.
+func (i *NgInterface) timestamp(high uint32, low uint32) time.Duration {
+	units := (uint64(high) << 32) | uint64(low)
+	var ts time.Duration
+
+	if i.TSResol&0x80 == 0 {
+		// The resolution is a negative power of ten.
+		exp := int(i.TSResol)
+		if exp <= 9 {
+			ts = time.Duration(units * pow10(9-exp))
+		} else {
+			ts = time.Duration(units / pow10(exp-9))
+		}
+	} else {
+		// The resolution is a negative power of two. Split off the whole seconds to avoid
+		// overflow when scaling the fractional part.
+		shift := uint(i.TSResol & 0x7F)
+		if shift >= 64 {
+			shift = 63
+		}
+		seconds := units >> shift
+		fraction := units & ((uint64(1) << shift) - 1)
+		hi, lo := bits.Mul64(fraction, uint64(time.Second))
+		nanos, _ := bits.Div64(hi, lo, uint64(1)<<shift)
+		ts = time.Duration(seconds)*time.Second + time.Duration(nanos)
+	}
+
+	return ts + time.Duration(i.TSOffset)*time.Second
+}
+
+// parseNgOptions splits the options section of a block into its individual options. Parsing stops
+// at the end-of-options marker or at the first malformed option.
+func parseNgOptions(data []byte, flipped bool) []ngOption {
+	options := make([]ngOption, 0)
+
+	for len(data) >= 4 {
+		code := getUint16(data[0:2], flipped)
+		length := int(getUint16(data[2:4], flipped))
+		data = data[4:]
+
+		if code == ngOptEndOfOpt || length > len(data) {
+			break
+		}
+
+		options = append(options, ngOption{code: code, value: data[:length]})
+
+		if ngPadded(length) > len(data) {
+			break
+		}
+		data = data[ngPadded(length):]
+	}
+
+	return options
+}
+
+// ngPadded rounds a length up to the 32-bit boundary used throughout pcapng.
+func ngPadded(length int) int {
+	return (length + 3) &^ 3
+}
+
+// pow10 returns 10 to the power of n.
+func pow10(n int) uint64 {
+	result := uint64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
+
+// splitNullTerminated splits a buffer containing a sequence of zero-terminated strings.
+func splitNullTerminated(data []byte) []string {
+	values := make([]string, 0)
+	start := 0
+
+	for i, b := range data {
+		if b == 0 {
+			if i > start {
+				values = append(values, string(data[start:i]))
+			}
+			start = i + 1
+		}
+	}
+
+	return values
+}
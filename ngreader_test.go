@@ -0,0 +1,262 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// A small ethernet frame containing a TCP segment, used to populate test pcapng files.
+var ngTestFrame = []byte{
+	0x00, 0x16, 0xE3, 0x19, 0x27, 0x15, 0x00, 0x04, 0x76, 0x96, 0x7B, 0xDA, 0x08, 0x00, 0x45, 0x00, 0x00, 0x52, 0x76, 0xED, 0x40, 0x00, 0x40, 0x06, 0x56, 0xCF,
+	0xC0, 0xA8, 0x01, 0x02, 0xD4, 0xCC, 0xD6, 0x72, 0x0B, 0x20, 0x1A, 0x0B, 0x4D, 0xC8, 0x4E, 0xED, 0x54, 0xF1, 0x10, 0x72, 0x80, 0x18, 0x1F, 0x4B, 0x6D, 0x2E,
+	0x00, 0x00, 0x01, 0x01, 0x08, 0x0A, 0x00, 0xD8, 0xEA, 0x48, 0x82, 0xE4, 0xDA, 0xB0, 0x49, 0x53, 0x4F, 0x4E, 0x20, 0x54, 0x68, 0x75, 0x6E, 0x66, 0x69, 0x73,
+	0x63, 0x68, 0x20, 0x53, 0x6D, 0x69, 0x6C, 0x65, 0x79, 0x20, 0x53, 0x6D, 0x69, 0x6C, 0x65, 0x79, 0x47, 0x0A,
+}
+
+// ngTestBuilder assembles pcapng blocks for tests, in either byte order.
+type ngTestBuilder struct {
+	buf     bytes.Buffer
+	flipped bool
+}
+
+func (b *ngTestBuilder) u16(v uint16) []byte {
+	if b.flipped {
+		return []byte{byte(v), byte(v >> 8)}
+	}
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func (b *ngTestBuilder) u32(v uint32) []byte {
+	if b.flipped {
+		return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	}
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func (b *ngTestBuilder) u64(v uint64) []byte {
+	if b.flipped {
+		return append(b.u32(uint32(v)), b.u32(uint32(v>>32))...)
+	}
+	return append(b.u32(uint32(v>>32)), b.u32(uint32(v))...)
+}
+
+func (b *ngTestBuilder) option(code uint16, value []byte) []byte {
+	out := append(b.u16(code), b.u16(uint16(len(value)))...)
+	out = append(out, value...)
+	return append(out, make([]byte, ngPadded(len(value))-len(value))...)
+}
+
+func (b *ngTestBuilder) block(blockType uint32, body ...[]byte) {
+	content := make([]byte, 0)
+	for _, part := range body {
+		content = append(content, part...)
+	}
+	content = append(content, make([]byte, ngPadded(len(content))-len(content))...)
+	length := uint32(len(content) + 12)
+
+	b.buf.Write(b.u32(blockType))
+	b.buf.Write(b.u32(length))
+	b.buf.Write(content)
+	b.buf.Write(b.u32(length))
+}
+
+// buildSection writes a section with one interface, a name record, an enhanced packet, a simple
+// packet and some interface statistics.
+func (b *ngTestBuilder) buildSection(comment string) {
+	b.block(ngBlockSectionHeader,
+		b.u32(ngByteOrderMagic), b.u16(1), b.u16(0), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		b.option(ngOptComment, []byte(comment)),
+		b.option(ngOptSHBUserAppl, []byte("gopcap")),
+		b.option(ngOptEndOfOpt, nil))
+	b.block(ngBlockInterfaceDescription,
+		b.u16(uint16(ETHERNET)), b.u16(0), b.u32(65535),
+		b.option(ngOptIfName, []byte("eth0")),
+		b.option(ngOptIfTSResol, []byte{9}),
+		b.option(ngOptEndOfOpt, nil))
+	b.block(ngBlockNameResolution,
+		b.u16(ngNameRecordIPv4), b.u16(16), []byte{192, 168, 1, 2}, []byte("example.com\x00"),
+		b.u16(ngNameRecordEnd), b.u16(0))
+	b.block(ngBlockEnhancedPacket,
+		b.u32(0), b.u32(0x0000000F), b.u32(0x42400015), b.u32(uint32(len(ngTestFrame))), b.u32(uint32(len(ngTestFrame))),
+		ngTestFrame, make([]byte, ngPadded(len(ngTestFrame))-len(ngTestFrame)),
+		b.option(ngOptComment, []byte("first packet")),
+		b.option(ngOptEPBFlags, b.u32(1)),
+		b.option(ngOptEndOfOpt, nil))
+	b.block(ngBlockSimplePacket, b.u32(uint32(len(ngTestFrame))), ngTestFrame)
+	b.block(ngBlockInterfaceStatistics,
+		b.u32(0), b.u32(0), b.u32(1000),
+		b.option(ngOptISBIfDrop, b.u64(7)),
+		b.option(ngOptEndOfOpt, nil))
+}
+
+func TestParseNg(t *testing.T) {
+	b := &ngTestBuilder{flipped: true}
+	b.buildSection("little endian")
+	b.flipped = false
+	b.buildSection("big endian")
+
+	parsed, err := ParseNg(&b.buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(parsed.Sections) != 2 {
+		t.Fatalf("Unexpected number of sections: expected %v, got %v.", 2, len(parsed.Sections))
+	}
+
+	expectedComments := []string{"little endian", "big endian"}
+	expectedTS := time.Duration(0x0000000F42400015)
+
+	for i, section := range parsed.Sections {
+		if section.MajorVersion != 1 || section.MinorVersion != 0 {
+			t.Errorf("Unexpected version: got %v.%v", section.MajorVersion, section.MinorVersion)
+		}
+		if len(section.Comments) != 1 || section.Comments[0] != expectedComments[i] {
+			t.Errorf("Unexpected section comments: expected %v, got %v", expectedComments[i], section.Comments)
+		}
+		if section.UserApplication != "gopcap" {
+			t.Errorf("Unexpected user application: expected %v, got %v", "gopcap", section.UserApplication)
+		}
+		if len(section.Interfaces) != 1 {
+			t.Fatalf("Unexpected number of interfaces: expected %v, got %v.", 1, len(section.Interfaces))
+		}
+
+		iface := section.Interfaces[0]
+		if iface.LinkType != ETHERNET || iface.SnapLen != 65535 || iface.Name != "eth0" || iface.TSResol != 9 {
+			t.Errorf("Unexpected interface: %+v", iface)
+		}
+		if iface.Statistics == nil || iface.Statistics.Dropped != 7 || iface.Statistics.Timestamp != 1000*time.Nanosecond {
+			t.Errorf("Unexpected interface statistics: %+v", iface.Statistics)
+		}
+
+		if len(section.Names) != 1 || !bytes.Equal(section.Names[0].Address, []byte{192, 168, 1, 2}) ||
+			len(section.Names[0].Names) != 1 || section.Names[0].Names[0] != "example.com" {
+			t.Errorf("Unexpected name records: %+v", section.Names)
+		}
+
+		if len(section.Packets) != 2 {
+			t.Fatalf("Unexpected number of packets: expected %v, got %v.", 2, len(section.Packets))
+		}
+
+		pkt := section.Packets[0]
+		if pkt.Timestamp != expectedTS {
+			t.Errorf("Unexpected TS: expected %v, got %v.", expectedTS, pkt.Timestamp)
+		}
+		if pkt.IncludedLen != uint32(len(ngTestFrame)) || pkt.ActualLen != uint32(len(ngTestFrame)) {
+			t.Errorf("Unexpected lengths: %v, %v", pkt.IncludedLen, pkt.ActualLen)
+		}
+		if len(pkt.Comments) != 1 || pkt.Comments[0] != "first packet" {
+			t.Errorf("Unexpected packet comments: %v", pkt.Comments)
+		}
+		if pkt.Flags != 1 {
+			t.Errorf("Unexpected packet flags: expected %v, got %v", 1, pkt.Flags)
+		}
+
+		for _, pkt := range section.Packets {
+			frame, ok := pkt.Data.(*EthernetFrame)
+			if !ok {
+				t.Fatalf("Unexpected link layer: %v", pkt.Data)
+			}
+			segment := frame.LinkData().InternetData().(*TCPSegment)
+			if segment.SourcePort != 2848 || len(segment.TransportData()) != 30 {
+				t.Errorf("Unexpected TCP segment: %+v", segment)
+			}
+		}
+	}
+}
+
+func TestNgInterfaceTimestamp(t *testing.T) {
+	in := []NgInterface{
+		NgInterface{TSResol: 6},
+		NgInterface{TSResol: 9},
+		NgInterface{TSResol: 3, TSOffset: 10},
+		NgInterface{TSResol: 0x80 | 10},
+	}
+	out := []time.Duration{
+		1500 * time.Microsecond,
+		1500 * time.Nanosecond,
+		10*time.Second + 1500*time.Millisecond,
+		1*time.Second + 464843750*time.Nanosecond,
+	}
+
+	for i, iface := range in {
+		ts := iface.timestamp(0, 1500)
+		if ts != out[i] {
+			t.Errorf("Unexpected timestamp: expected %v, got %v.", out[i], ts)
+		}
+	}
+}
+
+// A decimal resolution too fine to fit in a uint64 is rejected, rather than dividing by zero. The
+// interface still takes up its ID, so packets on later interfaces are read as usual.
+func TestNgReaderInvalidTSResol(t *testing.T) {
+	b := &ngTestBuilder{}
+	b.block(ngBlockSectionHeader, b.u32(ngByteOrderMagic), b.u16(1), b.u16(0), make([]byte, 8))
+	b.block(ngBlockInterfaceDescription,
+		b.u16(uint16(ETHERNET)), b.u16(0), b.u32(65535),
+		b.option(ngOptIfTSResol, []byte{100}),
+		b.option(ngOptEndOfOpt, nil))
+	b.block(ngBlockInterfaceDescription,
+		b.u16(uint16(RAW)), b.u16(0), b.u32(65535),
+		b.option(ngOptIfTSResol, []byte{9}),
+		b.option(ngOptEndOfOpt, nil))
+	for _, id := range []uint32{0, 1} {
+		b.block(ngBlockEnhancedPacket,
+			b.u32(id), b.u32(0), b.u32(1500), b.u32(uint32(len(ngTestFrame))), b.u32(uint32(len(ngTestFrame))),
+			ngTestFrame)
+	}
+
+	r, err := NewNgReader(&b.buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = r.Next()
+	if err != InvalidBlock {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidBlock, err)
+	}
+
+	_, err = r.Next()
+	if err != UnsupportedResolution {
+		t.Errorf("Unexpected error: expected %v, got %v", UnsupportedResolution, err)
+	}
+
+	pkt, err := r.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pkt.InterfaceID != 1 || pkt.Timestamp != 1500*time.Nanosecond || len(r.Section.Interfaces) != 2 {
+		t.Errorf("Unexpected packet: %+v", pkt)
+	}
+}
+
+func TestNgReaderNotPcapNg(t *testing.T) {
+	in := bytes.NewReader([]byte{0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00})
+	_, err := NewNgReader(in)
+
+	if err != NotAPcapFile {
+		t.Errorf("Unexpected error: expected %v, got %v", NotAPcapFile, err)
+	}
+}
+
+func TestNgReaderUnknownInterface(t *testing.T) {
+	b := &ngTestBuilder{}
+	b.block(ngBlockSectionHeader, b.u32(ngByteOrderMagic), b.u16(1), b.u16(0), make([]byte, 8))
+	b.block(ngBlockSimplePacket, b.u32(uint32(len(ngTestFrame))), ngTestFrame)
+
+	r, err := NewNgReader(&b.buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = r.Next()
+	if err != UnknownInterface {
+		t.Errorf("Unexpected error: expected %v, got %v", UnknownInterface, err)
+	}
+
+	_, err = r.Next()
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+	}
+}
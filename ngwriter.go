@@ -0,0 +1,224 @@
+package gopcap
+
+import (
+	"io"
+	"math/bits"
+	"time"
+)
+
+// NgWriter writes packets to a .pcapng file. The Section Header Block is written when the NgWriter
+// is created. Interfaces are then added with AddInterface, and packets captured on them are written
+// with WritePacket.
+type NgWriter struct {
+	dst        io.Writer
+	flipped    bool
+	interfaces []NgInterface
+}
+
+// NewNgWriter creates an NgWriter and writes a Section Header Block to dst. The version, hardware,
+// OS, user application and comments are taken from the NgSection, and an Interface Description
+// Block is written for each of its Interfaces. Any Packets and Names it contains are ignored. A
+// zero version is written as 1.0. If flipped is set, the file is written in reversed
+// (little-endian) byte order.
+func NewNgWriter(dst io.Writer, section NgSection, flipped bool) (*NgWriter, error) {
+	w := &NgWriter{dst: dst, flipped: flipped}
+
+	if section.MajorVersion == 0 && section.MinorVersion == 0 {
+		section.MajorVersion = 1
+	}
+
+	body := make([]byte, 16)
+	putUint32(body[0:4], ngByteOrderMagic, flipped)
+	putUint16(body[4:6], section.MajorVersion, flipped)
+	putUint16(body[6:8], section.MinorVersion, flipped)
+
+	// The section length is unknown, since we're writing a stream.
+	putUint64(body[8:16], 0xFFFFFFFFFFFFFFFF, flipped)
+
+	options := w.commentOptions(section.Comments)
+	options = appendStringOption(options, ngOptSHBHardware, section.Hardware)
+	options = appendStringOption(options, ngOptSHBOS, section.OS)
+	options = appendStringOption(options, ngOptSHBUserAppl, section.UserApplication)
+
+	err := w.writeBlock(ngBlockSectionHeader, body, options)
+	if err != nil {
+		return w, err
+	}
+
+	for _, iface := range section.Interfaces {
+		_, err = w.AddInterface(iface)
+		if err != nil {
+			return w, err
+		}
+	}
+
+	return w, nil
+}
+
+// AddInterface writes an Interface Description Block and returns the ID to use when writing packets
+// captured on that interface. TSResol is written as it is, so a zero TSResol means timestamps in
+// seconds: set it to 6 for the usual microseconds. A decimal TSResol above 19 can't be represented,
+// and returns UnsupportedResolution.
+func (w *NgWriter) AddInterface(iface NgInterface) (uint32, error) {
+	if !iface.validTSResol() {
+		return 0, UnsupportedResolution
+	}
+
+	body := make([]byte, 8)
+	putUint16(body[0:2], uint16(iface.LinkType), w.flipped)
+	putUint32(body[4:8], iface.SnapLen, w.flipped)
+
+	options := w.commentOptions(iface.Comments)
+	options = appendStringOption(options, ngOptIfName, iface.Name)
+	options = appendStringOption(options, ngOptIfDescription, iface.Description)
+	options = appendStringOption(options, ngOptIfOS, iface.OS)
+
+	if iface.Speed != 0 {
+		value := make([]byte, 8)
+		putUint64(value, iface.Speed, w.flipped)
+		options = append(options, ngOption{code: ngOptIfSpeed, value: value})
+	}
+	if iface.TSResol != 6 {
+		options = append(options, ngOption{code: ngOptIfTSResol, value: []byte{iface.TSResol}})
+	}
+	if iface.TSOffset != 0 {
+		value := make([]byte, 8)
+		putUint64(value, uint64(iface.TSOffset), w.flipped)
+		options = append(options, ngOption{code: ngOptIfTSOffset, value: value})
+	}
+
+	err := w.writeBlock(ngBlockInterfaceDescription, body, options)
+	if err != nil {
+		return 0, err
+	}
+
+	w.interfaces = append(w.interfaces, iface)
+	return uint32(len(w.interfaces) - 1), nil
+}
+
+// WritePacket writes a single packet as an Enhanced Packet Block. The interface, timestamp, original
+// length, flags, drop count and comments are taken from the NgPacket, and data holds the captured
+// bytes of the packet. As with Writer.WritePacket, Time is used in preference to Timestamp if it is
+// set, and the captured length is always the length of data. A comment longer than 65535 bytes
+// can't be written, and returns InvalidField.
+func (w *NgWriter) WritePacket(pkt NgPacket, data []byte) error {
+	if uint64(pkt.InterfaceID) >= uint64(len(w.interfaces)) {
+		return UnknownInterface
+	}
+	iface := &w.interfaces[pkt.InterfaceID]
+
+	includedLen := uint32(len(data))
+	actualLen := pkt.ActualLen
+	if actualLen < includedLen {
+		actualLen = includedLen
+	}
+
+	ts := pkt.Timestamp
+	if !pkt.Time.IsZero() {
+		ts = fileTimestamp(pkt.Time, 0)
+	}
+	units := iface.units(ts)
+
+	body := make([]byte, 20+ngPadded(len(data)))
+	putUint32(body[0:4], pkt.InterfaceID, w.flipped)
+	putUint32(body[4:8], uint32(units>>32), w.flipped)
+	putUint32(body[8:12], uint32(units), w.flipped)
+	putUint32(body[12:16], includedLen, w.flipped)
+	putUint32(body[16:20], actualLen, w.flipped)
+	copy(body[20:], data)
+
+	options := w.commentOptions(pkt.Comments)
+	if pkt.Flags != 0 {
+		value := make([]byte, 4)
+		putUint32(value, pkt.Flags, w.flipped)
+		options = append(options, ngOption{code: ngOptEPBFlags, value: value})
+	}
+	if pkt.DropCount != 0 {
+		value := make([]byte, 8)
+		putUint64(value, pkt.DropCount, w.flipped)
+		options = append(options, ngOption{code: ngOptEPBDropCount, value: value})
+	}
+
+	return w.writeBlock(ngBlockEnhancedPacket, body, options)
+}
+
+// writeBlock writes a complete block: the type, the length, the body, the options and the length
+// again. The body must already be padded to 32 bits. An option value too long for its 16-bit length
+// returns InvalidField, and nothing is written.
+func (w *NgWriter) writeBlock(blockType uint32, body []byte, options []ngOption) error {
+	// Work out how long the block will be.
+	length := 12 + len(body)
+	for _, opt := range options {
+		if len(opt.value) > 0xFFFF {
+			return InvalidField
+		}
+		length += 4 + ngPadded(len(opt.value))
+	}
+	if len(options) > 0 {
+		length += 4
+	}
+
+	buffer := make([]byte, length)
+	putUint32(buffer[0:4], blockType, w.flipped)
+	putUint32(buffer[4:8], uint32(length), w.flipped)
+	offset := 8 + copy(buffer[8:], body)
+
+	for _, opt := range options {
+		putUint16(buffer[offset:offset+2], opt.code, w.flipped)
+		putUint16(buffer[offset+2:offset+4], uint16(len(opt.value)), w.flipped)
+		copy(buffer[offset+4:], opt.value)
+		offset += 4 + ngPadded(len(opt.value))
+	}
+
+	// The end-of-options marker is all zeroes, and so is the padding, so there's nothing to
+	// write for either. All that remains is the trailing length.
+	putUint32(buffer[length-4:], uint32(length), w.flipped)
+
+	_, err := w.dst.Write(buffer)
+	return err
+}
+
+// commentOptions builds an opt_comment option for each comment.
+func (w *NgWriter) commentOptions(comments []string) []ngOption {
+	options := make([]ngOption, 0, len(comments))
+	for _, comment := range comments {
+		options = appendStringOption(options, ngOptComment, comment)
+	}
+	return options
+}
+
+// appendStringOption adds a string-valued option to a list of options, unless the string is empty.
+func appendStringOption(options []ngOption, code uint16, value string) []ngOption {
+	if value == "" {
+		return options
+	}
+	return append(options, ngOption{code: code, value: []byte(value)})
+}
+
+// units converts a time.Duration since the epoch into a pcapng timestamp, using the resolution and
+// offset of the interface. It is the inverse of timestamp. The resolution must be valid, which
+// AddInterface checks.
+func (i *NgInterface) units(ts time.Duration) uint64 {
+	ts -= time.Duration(i.TSOffset) * time.Second
+
+	if i.TSResol&0x80 == 0 {
+		exp := int(i.TSResol)
+		if exp <= 9 {
+			return uint64(ts) / pow10(9-exp)
+		}
+		return uint64(ts) * pow10(exp-9)
+	}
+
+	shift := uint(i.TSResol & 0x7F)
+	if shift >= 64 {
+		shift = 63
+	}
+	seconds := uint64(ts / time.Second)
+	nanos := uint64(ts % time.Second)
+
+	// Scale the nanoseconds to units of 2^-shift seconds.
+	hi, lo := bits.Mul64(nanos, uint64(1)<<shift)
+	fraction, _ := bits.Div64(hi, lo, uint64(time.Second))
+
+	return (seconds << shift) + fraction
+}
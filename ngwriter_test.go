@@ -0,0 +1,203 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Files written by the NgWriter should read back identically with the NgReader.
+func TestNgWriterRoundTrip(t *testing.T) {
+	section := NgSection{
+		Comments:        []string{"A section comment"},
+		UserApplication: "gopcap",
+		Interfaces: []NgInterface{
+			NgInterface{LinkType: ETHERNET, SnapLen: 65535, Name: "eth0", TSResol: 6},
+		},
+	}
+	pkt := NgPacket{
+		Packet:   Packet{Timestamp: 1415577600*time.Second + 123456789*time.Nanosecond},
+		Comments: []string{"A packet comment", "Another packet comment"},
+		Flags:    1,
+	}
+
+	for _, flipped := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		w, err := NewNgWriter(buf, section, flipped)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		id, err := w.AddInterface(NgInterface{LinkType: RAW, Name: "tun0", TSResol: 9, Comments: []string{"tunnel"}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if id != 1 {
+			t.Errorf("Unexpected interface ID: expected %v, got %v.", 1, id)
+		}
+
+		// One packet on each interface.
+		w.WritePacket(pkt, ngTestFrame)
+		pkt.InterfaceID = id
+		w.WritePacket(pkt, ngTestFrame[14:])
+		pkt.InterfaceID = 0
+
+		err = w.WritePacket(NgPacket{InterfaceID: 2}, ngTestFrame)
+		if err != UnknownInterface {
+			t.Errorf("Unexpected error: expected %v, got %v", UnknownInterface, err)
+		}
+
+		parsed, err := ParseNg(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(parsed.Sections) != 1 {
+			t.Fatalf("Unexpected number of sections: expected %v, got %v.", 1, len(parsed.Sections))
+		}
+
+		out := parsed.Sections[0]
+		if out.MajorVersion != 1 || out.MinorVersion != 0 {
+			t.Errorf("Unexpected version: got %v.%v", out.MajorVersion, out.MinorVersion)
+		}
+		if len(out.Comments) != 1 || out.Comments[0] != "A section comment" || out.UserApplication != "gopcap" {
+			t.Errorf("Unexpected section: %+v", out)
+		}
+		if len(out.Interfaces) != 2 {
+			t.Fatalf("Unexpected number of interfaces: expected %v, got %v.", 2, len(out.Interfaces))
+		}
+		if out.Interfaces[0].LinkType != ETHERNET || out.Interfaces[0].Name != "eth0" || out.Interfaces[0].TSResol != 6 {
+			t.Errorf("Unexpected interface: %+v", out.Interfaces[0])
+		}
+		if out.Interfaces[1].LinkType != RAW || out.Interfaces[1].TSResol != 9 || len(out.Interfaces[1].Comments) != 1 {
+			t.Errorf("Unexpected interface: %+v", out.Interfaces[1])
+		}
+		if len(out.Packets) != 2 {
+			t.Fatalf("Unexpected number of packets: expected %v, got %v.", 2, len(out.Packets))
+		}
+
+		// The first interface only has microsecond resolution.
+		expectedTS := []time.Duration{pkt.Timestamp.Truncate(time.Microsecond), pkt.Timestamp}
+		expectedLen := []uint32{uint32(len(ngTestFrame)), uint32(len(ngTestFrame) - 14)}
+
+		for i, p := range out.Packets {
+			if p.InterfaceID != uint32(i) {
+				t.Errorf("Unexpected interface ID: expected %v, got %v.", i, p.InterfaceID)
+			}
+			if p.Timestamp != expectedTS[i] {
+				t.Errorf("Unexpected TS: expected %v, got %v.", expectedTS[i], p.Timestamp)
+			}
+			if !p.Time.Equal(time.Unix(0, int64(expectedTS[i]))) {
+				t.Errorf("Unexpected time: expected %v, got %v.", time.Unix(0, int64(expectedTS[i])), p.Time)
+			}
+			if p.IncludedLen != expectedLen[i] || p.ActualLen != expectedLen[i] {
+				t.Errorf("Unexpected lengths: %v, %v", p.IncludedLen, p.ActualLen)
+			}
+			if len(p.Comments) != 2 || p.Comments[0] != pkt.Comments[0] || p.Comments[1] != pkt.Comments[1] {
+				t.Errorf("Unexpected comments: %v", p.Comments)
+			}
+			if p.Flags != 1 {
+				t.Errorf("Unexpected flags: expected %v, got %v.", 1, p.Flags)
+			}
+		}
+
+		if _, ok := out.Packets[0].Data.(*EthernetFrame); !ok {
+			t.Errorf("Unexpected link layer: %v", out.Packets[0].Data)
+		}
+		if _, ok := out.Packets[1].Data.(*UnknownLink); !ok {
+			t.Errorf("Unexpected link layer: %v", out.Packets[1].Data)
+		}
+	}
+}
+
+func TestNgInterfaceUnits(t *testing.T) {
+	in := []NgInterface{
+		NgInterface{TSResol: 0},
+		NgInterface{TSResol: 6},
+		NgInterface{TSResol: 9},
+		NgInterface{TSResol: 3, TSOffset: 10},
+		NgInterface{TSResol: 0x80 | 10},
+	}
+
+	// Converting to units and back should be the identity, to within the interface resolution.
+	for _, iface := range in {
+		units := iface.units(iface.timestamp(0, 1500))
+		if units != 1500 {
+			t.Errorf("Unexpected units: expected %v, got %v.", 1500, units)
+		}
+	}
+}
+
+func TestNgWriterInvalidTSResol(t *testing.T) {
+	w, err := NewNgWriter(new(bytes.Buffer), NgSection{}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = w.AddInterface(NgInterface{LinkType: ETHERNET, TSResol: 20})
+	if err != UnsupportedResolution {
+		t.Errorf("Unexpected error: expected %v, got %v", UnsupportedResolution, err)
+	}
+	_, err = w.AddInterface(NgInterface{LinkType: ETHERNET, TSResol: 19})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// A zero TSResol means seconds, and survives a round trip.
+func TestNgWriterSecondsTSResol(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewNgWriter(buf, NgSection{Interfaces: []NgInterface{NgInterface{LinkType: ETHERNET}}}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w.WritePacket(NgPacket{Packet: Packet{Timestamp: 1415577600 * time.Second}}, ngTestFrame)
+
+	out, err := ParseNg(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	section := out.Sections[0]
+	if section.Interfaces[0].TSResol != 0 || section.Packets[0].Timestamp != 1415577600*time.Second {
+		t.Errorf("Unexpected interface %+v and timestamp %v", section.Interfaces[0], section.Packets[0].Timestamp)
+	}
+}
+
+// An option value that doesn't fit in its 16-bit length is rejected, and nothing is written.
+func TestNgWriterLongComment(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := NewNgWriter(buf, NgSection{Interfaces: []NgInterface{NgInterface{LinkType: ETHERNET}}}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	size := buf.Len()
+
+	err = w.WritePacket(NgPacket{Comments: []string{strings.Repeat("x", 0x10000)}}, ngTestFrame)
+	if err != InvalidField || buf.Len() != size {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidField, err)
+	}
+
+	err = w.WritePacket(NgPacket{Comments: []string{strings.Repeat("x", 0xFFFF)}}, ngTestFrame)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestNgWriterEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := NewNgWriter(buf, NgSection{}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r, err := NewNgReader(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = r.Next()
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+	}
+}
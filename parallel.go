@@ -0,0 +1,174 @@
+package gopcap
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ParallelOptions configures a ParallelReader.
+type ParallelOptions struct {
+	Workers   int  // The number of decoding goroutines. Defaults to runtime.NumCPU().
+	Window    int  // The maximum number of packets in flight at once. Defaults to 64 per worker.
+	Unordered bool // If set, packets are returned as soon as they are decoded, in any order.
+}
+
+// ParallelReader reads packets from a Reader, decoding them on a pool of worker goroutines. Records
+// are read from the file on a single goroutine, and at most Window packets are held in memory at any
+// one time. Like Reader, packets are fetched one at a time by calling Next.
+type ParallelReader struct {
+	unordered bool
+	slots     chan struct{}
+	jobs      chan parallelPacket
+	results   chan parallelPacket
+	done      chan struct{}
+	closing   sync.Once
+
+	// State used only by Next: the reorder buffer, and the error that stopped the reading.
+	pending  map[uint64]parallelPacket
+	nextSeq  uint64
+	finalErr error
+}
+
+// parallelPacket is a single packet passing through the pipeline, tagged with its position in the
+// file. The last one through is marked as final, and carries the error that stopped the reading
+// instead of a packet.
+type parallelPacket struct {
+	seq   uint64
+	pkt   Packet
+	data  []byte
+	err   error
+	final bool
+}
+
+// NewParallelReader starts decoding packets from r in the background. The Reader must not be used
+// directly once it has been handed to a ParallelReader. Call Close to stop early.
+func NewParallelReader(r *Reader, opts ParallelOptions) *ParallelReader {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.Window <= 0 {
+		opts.Window = 64 * opts.Workers
+	}
+
+	p := &ParallelReader{
+		unordered: opts.Unordered,
+		slots:     make(chan struct{}, opts.Window),
+		jobs:      make(chan parallelPacket, opts.Window),
+		results:   make(chan parallelPacket, opts.Window),
+		done:      make(chan struct{}),
+		pending:   make(map[uint64]parallelPacket),
+		finalErr:  io.EOF,
+	}
+
+	go p.frame(r)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go p.decode(r.LinkType, wg)
+	}
+
+	// Once every worker has finished, there are no more results.
+	go func() {
+		wg.Wait()
+		close(p.results)
+	}()
+
+	return p
+}
+
+// Next returns the next decoded packet. When there are no more packets it returns io.EOF, or
+// UnexpectedEOF if the file ended partway through a packet. A packet that fails to decode is
+// returned along with its error, and reading can continue past it.
+func (p *ParallelReader) Next() (Packet, error) {
+	for {
+		select {
+		case <-p.done:
+			return Packet{}, io.EOF
+		default:
+		}
+
+		// In order mode, the packet we want may already be waiting.
+		if res, ok := p.pending[p.nextSeq]; ok && !p.unordered {
+			delete(p.pending, p.nextSeq)
+			return p.deliver(res)
+		}
+
+		res, ok := <-p.results
+		if !ok {
+			return Packet{}, p.finalErr
+		}
+
+		// Hold on to the final error until every packet has been delivered.
+		if res.final {
+			p.finalErr = res.err
+		} else if p.unordered {
+			return p.deliver(res)
+		} else {
+			p.pending[res.seq] = res
+		}
+	}
+}
+
+// Close stops reading and decoding. Any packets not yet returned by Next are discarded, and Next
+// returns io.EOF.
+func (p *ParallelReader) Close() {
+	p.closing.Do(func() {
+		close(p.done)
+	})
+}
+
+// deliver hands a packet to the caller, freeing up its slot in the window.
+func (p *ParallelReader) deliver(res parallelPacket) (Packet, error) {
+	p.nextSeq++
+	<-p.slots
+	return res.pkt, res.err
+}
+
+// frame reads records from the file and queues them for decoding. It runs on its own goroutine.
+func (p *ParallelReader) frame(r *Reader) {
+	defer close(p.jobs)
+
+	for seq := uint64(0); ; seq++ {
+		// Wait for space in the window before reading another record.
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.done:
+			return
+		}
+
+		job := parallelPacket{seq: seq}
+		job.pkt, job.data, job.err = r.ReadRecord()
+		job.final = job.err != nil
+
+		select {
+		case p.jobs <- job:
+		case <-p.done:
+			return
+		}
+
+		if job.final {
+			return
+		}
+	}
+}
+
+// decode parses the packet data of queued records. Several of these run at once, each on its own
+// goroutine.
+func (p *ParallelReader) decode(linkType Link, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for job := range p.jobs {
+		if !job.final {
+			job.pkt.Data, job.err = parseLinkData(job.data, linkType)
+			job.data = nil
+		}
+
+		select {
+		case p.results <- job:
+		case <-p.done:
+			return
+		}
+	}
+}
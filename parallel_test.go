@@ -0,0 +1,146 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// In order mode, the parallel reader should return exactly what the Reader does.
+func TestParallelReaderOrdered(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	expected, _ := Parse(bytes.NewReader(original))
+
+	r, err := NewReader(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p := NewParallelReader(r, ParallelOptions{Workers: 4, Window: 8})
+	defer p.Close()
+
+	count := 0
+	for {
+		pkt, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if pkt.Timestamp != expected.Packets[count].Timestamp || pkt.IncludedLen != expected.Packets[count].IncludedLen {
+			t.Fatalf("Unexpected packet %v: expected %+v, got %+v.", count, expected.Packets[count], pkt)
+		}
+		if _, ok := pkt.Data.(*EthernetFrame); !ok {
+			t.Errorf("Unexpected link layer: %v", pkt.Data)
+		}
+		count++
+	}
+
+	if count != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, count)
+	}
+
+	// Once finished, it stays finished.
+	_, err = p.Next()
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+	}
+}
+
+// In unordered mode, every packet should still be returned exactly once.
+func TestParallelReaderUnordered(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	expected, _ := Parse(bytes.NewReader(original))
+	seen := make(map[time.Duration]int)
+	for _, pkt := range expected.Packets {
+		seen[pkt.Timestamp]++
+	}
+
+	r, _ := NewReader(bytes.NewReader(original))
+	p := NewParallelReader(r, ParallelOptions{Unordered: true})
+	defer p.Close()
+
+	count := 0
+	for {
+		pkt, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		seen[pkt.Timestamp]--
+		count++
+	}
+
+	if count != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, count)
+	}
+	for ts, n := range seen {
+		if n != 0 {
+			t.Errorf("Packet with TS %v seen the wrong number of times.", ts)
+		}
+	}
+}
+
+func TestParallelReaderTruncated(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	r, _ := NewReader(bytes.NewReader(original[:len(original)-10]))
+	p := NewParallelReader(r, ParallelOptions{Workers: 2})
+	defer p.Close()
+
+	count := 0
+	for {
+		_, err = p.Next()
+		if err != nil {
+			break
+		}
+		count++
+	}
+
+	if err != UnexpectedEOF {
+		t.Errorf("Unexpected error: expected %v, got %v", UnexpectedEOF, err)
+	}
+	if count != 2262 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2262, count)
+	}
+}
+
+// Closing part way through should stop the pipeline without blocking.
+func TestParallelReaderClose(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	r, _ := NewReader(src)
+	p := NewParallelReader(r, ParallelOptions{Workers: 2, Window: 4})
+
+	for i := 0; i < 10; i++ {
+		p.Next()
+	}
+	p.Close()
+	p.Close()
+
+	for {
+		_, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+	}
+}
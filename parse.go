@@ -7,63 +7,86 @@ import (
 )
 
 // checkMagicNum checks the first four bytes of a pcap file, searching for the magic number
-// and checking the byte order. Returns three values: whether the file is a pcap file, whether
-// the byte order needs flipping, and any error that was encountered. If error is returned,
-// the other values are invalid.
-func checkMagicNum(src io.Reader) (bool, bool, error) {
-	// These magic numbers form the header of a pcap file.
+// and checking the byte order. Returns four values: whether the file is a pcap file, whether
+// the byte order needs flipping, the resolution of the packet timestamps, and any error that was
+// encountered. If error is returned, the other values are invalid.
+func checkMagicNum(src io.Reader) (bool, bool, time.Duration, error) {
+	// These magic numbers form the header of a pcap file. Files with nanosecond timestamps use
+	// a different magic number to those with microsecond timestamps.
 	magic := []byte{0xa1, 0xb2, 0xc3, 0xd4}
 	magic_reverse := []byte{0xd4, 0xc3, 0xb2, 0xa1}
+	magic_nano := []byte{0xa1, 0xb2, 0x3c, 0x4d}
+	magic_nano_reverse := []byte{0x4d, 0x3c, 0xb2, 0xa1}
 
 	buffer := make([]byte, 4)
-	read_count, err := src.Read(buffer)
+	_, err := io.ReadFull(src, buffer)
 
-	if read_count != 4 {
-		return false, false, InsufficientLength
-	}
-	if (err != nil) && (err != io.EOF) {
-		return false, false, err
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return false, false, 0, InsufficientLength
+	} else if err != nil {
+		return false, false, 0, err
 	}
 
 	if bytes.Compare(buffer, magic) == 0 {
-		return true, false, nil
+		return true, false, time.Microsecond, nil
 	} else if bytes.Compare(buffer, magic_reverse) == 0 {
-		return true, true, nil
+		return true, true, time.Microsecond, nil
+	} else if bytes.Compare(buffer, magic_nano) == 0 {
+		return true, false, time.Nanosecond, nil
+	} else if bytes.Compare(buffer, magic_nano_reverse) == 0 {
+		return true, true, time.Nanosecond, nil
 	}
 
-	return false, false, NotAPcapFile
+	return false, false, 0, NotAPcapFile
 }
 
 // parsePacket parses a full packet out of the pcap file. It returns an error if any problems were
-// encountered.
-func parsePacket(pkt *Packet, src io.Reader, flipped bool, linkType Link) error {
-	err := populatePacketHeader(pkt, src, flipped)
-
+// encountered. If the file ends cleanly before the packet, the error is io.EOF; if it ends partway
+// through the packet, the error is UnexpectedEOF.
+func parsePacket(pkt *Packet, src io.Reader, flipped bool, resolution time.Duration, linkType Link) error {
+	data, err := readRecord(pkt, src, flipped, resolution)
 	if err != nil {
 		return err
 	}
 
-	data := make([]byte, pkt.IncludedLen)
-	readlen, err := src.Read(data)
-	if uint32(readlen) != pkt.IncludedLen {
-		return UnexpectedEOF
-	}
-
 	pkt.Data, err = parseLinkData(data, linkType)
 
 	return err
 }
 
+// readRecord reads a packet record out of the pcap file, populating the packet header and returning
+// the raw packet data without parsing it. Errors are as for parsePacket. If the file ends partway
+// through the packet data, whatever data could be read is returned along with UnexpectedEOF.
+func readRecord(pkt *Packet, src io.Reader, flipped bool, resolution time.Duration) ([]byte, error) {
+	err := populatePacketHeader(pkt, src, flipped, resolution)
+
+	if err == InsufficientLength {
+		return nil, UnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, pkt.IncludedLen)
+	read_count, err := io.ReadFull(src, data)
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return data[:read_count], UnexpectedEOF
+	} else if err != nil {
+		return data[:read_count], err
+	}
+
+	return data, nil
+}
+
 // populateFileHeader reads the next 20 bytes out of the .pcap file and uses it to populate the
 // PcapFile structure.
 func populateFileHeader(file *PcapFile, src io.Reader, flipped bool) error {
 	buffer := make([]byte, 20)
-	read_count, err := src.Read(buffer)
+	_, err := io.ReadFull(src, buffer)
 
-	if err != nil {
-		return err
-	} else if read_count != 20 {
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
 		return InsufficientLength
+	} else if err != nil {
+		return err
 	}
 
 	// First two bytes are the major version number.
@@ -72,7 +95,7 @@ func populateFileHeader(file *PcapFile, src io.Reader, flipped bool) error {
 	// Next two are the minor version number.
 	file.MinorVersion = getUint16(buffer[2:4], flipped)
 
-	// GMT to local correction, in seconds east of UTC.
+	// GMT to local correction, in seconds, as added to local time to get UTC.
 	file.TZCorrection = getInt32(buffer[4:8], flipped)
 
 	// Next is the number of significant figures in the timestamps. Almost always zero.
@@ -88,21 +111,24 @@ func populateFileHeader(file *PcapFile, src io.Reader, flipped bool) error {
 }
 
 // populatePacketHeader reads the next 16 bytes out of the file and builds it into a
-// packet header.
-func populatePacketHeader(packet *Packet, src io.Reader, flipped bool) error {
+// packet header. The resolution is the duration of one unit of the sub-second part of the
+// timestamp: time.Microsecond for most files, time.Nanosecond for nanosecond pcap files.
+// Returns io.EOF if there are no more bytes at all, or InsufficientLength if the file ends
+// partway through the header.
+func populatePacketHeader(packet *Packet, src io.Reader, flipped bool, resolution time.Duration) error {
 	buffer := make([]byte, 16)
-	read_count, err := src.Read(buffer)
+	_, err := io.ReadFull(src, buffer)
 
-	if err != nil {
-		return err
-	} else if read_count != 16 {
+	if err == io.ErrUnexpectedEOF {
 		return InsufficientLength
+	} else if err != nil {
+		return err
 	}
 
 	// First is a pair of fields that build up the timestamp.
 	ts_seconds := getUint32(buffer[0:4], flipped)
-	ts_micros := getUint32(buffer[4:8], flipped)
-	packet.Timestamp = (time.Duration(ts_seconds) * time.Second) + (time.Duration(ts_micros) * time.Microsecond)
+	ts_fraction := getUint32(buffer[4:8], flipped)
+	packet.Timestamp = (time.Duration(ts_seconds) * time.Second) + (time.Duration(ts_fraction) * resolution)
 
 	// Next is the length of the data segment.
 	packet.IncludedLen = getUint32(buffer[8:12], flipped)
@@ -110,7 +136,20 @@ func populatePacketHeader(packet *Packet, src io.Reader, flipped bool) error {
 	// Then the original length of the packet.
 	packet.ActualLen = getUint32(buffer[12:16], flipped)
 
-	return err
+	return nil
+}
+
+// absoluteTime converts a packet timestamp into an absolute time in UTC. Files with a non-zero
+// TZCorrection record local time. As libpcap defines it, the correction is what has to be added to
+// local time to get UTC, so it's -3600 for GMT+1.
+func absoluteTime(ts time.Duration, tzCorrection int32) time.Time {
+	return time.Unix(0, int64(ts+time.Duration(tzCorrection)*time.Second)).UTC()
+}
+
+// fileTimestamp is the inverse of absoluteTime: it converts an absolute time into a timestamp for a
+// file with the given TZCorrection.
+func fileTimestamp(t time.Time, tzCorrection int32) time.Duration {
+	return time.Duration(t.UnixNano()) - time.Duration(tzCorrection)*time.Second
 }
 
 // parseLinkData takes the data buffer containing the full link-layer packet (or equivalent, e.g.
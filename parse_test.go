@@ -1,14 +1,20 @@
 package gopcap
 
 import (
+	"io"
 	"testing"
 	"time"
 )
 
 type byteReader []byte
 
+// Read copies as much of the data as fits into p. Like any io.Reader, it signals the end of the data
+// if it can't fill p.
 func (r byteReader) Read(p []byte) (int, error) {
 	n := copy(p, r)
+	if n < len(p) {
+		return n, io.EOF
+	}
 	return n, nil
 }
 
@@ -16,16 +22,19 @@ func TestCheckMagicNum(t *testing.T) {
 	in := []byteReader{
 		byteReader{0xa1, 0xb2, 0xc3, 0xd4},
 		byteReader{0xd4, 0xc3, 0xb2, 0xa1},
+		byteReader{0xa1, 0xb2, 0x3c, 0x4d},
+		byteReader{0x4d, 0x3c, 0xb2, 0xa1},
 		byteReader{0xd4, 0xc3, 0xb2, 0xa0},
 		byteReader{0xd4, 0xc3, 0xb2},
 	}
 
-	first := []bool{true, true, false, false}
-	second := []bool{false, true, false, false}
-	third := []error{nil, nil, NotAPcapFile, InsufficientLength}
+	first := []bool{true, true, true, true, false, false}
+	second := []bool{false, true, false, true, false, false}
+	third := []error{nil, nil, nil, nil, NotAPcapFile, InsufficientLength}
+	resolutions := []time.Duration{time.Microsecond, time.Microsecond, time.Nanosecond, time.Nanosecond, 0, 0}
 
 	for i, input := range in {
-		out1, out2, out3 := checkMagicNum(input)
+		out1, out2, res, out3 := checkMagicNum(input)
 
 		if out1 != first[i] {
 			t.Errorf("Unexpected first return val: expected %v, got %v.", first[i], out1)
@@ -35,6 +44,10 @@ func TestCheckMagicNum(t *testing.T) {
 			t.Errorf("Unexpected second return val: expected %v, got %v.", second[i], out2)
 		}
 
+		if res != resolutions[i] {
+			t.Errorf("Unexpected resolution: expected %v, got %v.", resolutions[i], res)
+		}
+
 		if out3 != third[i] {
 			t.Errorf("Unexpected third return val: expected %v, got %v.", third[i], out3)
 		}
@@ -44,7 +57,7 @@ func TestCheckMagicNum(t *testing.T) {
 func TestPopulatePacketHeaderGood(t *testing.T) {
 	in := byteReader{0xfa, 0x4f, 0xef, 0x44, 0x64, 0xfd, 0x09, 0x00, 0x60, 0x00, 0x00, 0x00, 0x60, 0x00, 0x00, 0x00, 0x00}
 	pkt := new(Packet)
-	err := populatePacketHeader(pkt, in, true)
+	err := populatePacketHeader(pkt, in, true, time.Microsecond)
 	correct_ts := 321259*time.Hour + 31*time.Minute + 6*time.Second + 654*time.Millisecond + 692*time.Microsecond
 
 	if err != nil {
@@ -61,16 +74,40 @@ func TestPopulatePacketHeaderGood(t *testing.T) {
 	}
 }
 
+func TestPopulatePacketHeaderNano(t *testing.T) {
+	in := byteReader{0xfa, 0x4f, 0xef, 0x44, 0x15, 0xcd, 0x5b, 0x07, 0x60, 0x00, 0x00, 0x00, 0x60, 0x00, 0x00, 0x00}
+	pkt := new(Packet)
+	err := populatePacketHeader(pkt, in, true, time.Nanosecond)
+	correct_ts := 321259*time.Hour + 31*time.Minute + 6*time.Second + 123456789*time.Nanosecond
+
+	if err != nil {
+		t.Errorf("Received unexpected error: %v", err)
+	}
+	if pkt.Timestamp != correct_ts {
+		t.Errorf("Incorrect timestamp: expected %v, got %v", correct_ts, pkt.Timestamp)
+	}
+}
+
 func TestPopulatePacketHeaderErr(t *testing.T) {
 	in := byteReader{0xfa}
 	pkt := new(Packet)
-	err := populatePacketHeader(pkt, in, false)
+	err := populatePacketHeader(pkt, in, false, time.Microsecond)
 
 	if err != InsufficientLength {
 		t.Errorf("Unexpected error: expected %v, got %v", InsufficientLength, err)
 	}
 }
 
+func TestPopulatePacketHeaderEOF(t *testing.T) {
+	in := byteReader{}
+	pkt := new(Packet)
+	err := populatePacketHeader(pkt, in, false, time.Microsecond)
+
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+	}
+}
+
 func TestPopulateFileHeaderGood(t *testing.T) {
 	in := byteReader{0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}
 	fle := new(PcapFile)
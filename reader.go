@@ -0,0 +1,122 @@
+package gopcap
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// Reader is a streaming parser for .pcap files. Unlike Parse, it does not hold the whole file in
+// memory: the file header is read when the Reader is created, and packets are then read one at a
+// time by calling Next.
+//
+// By default, a corrupt record header stops the Reader, as it leaves no way to find the next record.
+// If Recover is set, the Reader instead checks every record header for plausibility, and when it
+// finds a bad one scans forward for the next record that looks valid. The bytes skipped over are
+// listed in Skipped. In recovery mode a file that ends partway through a record header is treated
+// as corrupt, so Next returns io.EOF rather than UnexpectedEOF.
+type Reader struct {
+	MajorVersion uint16
+	MinorVersion uint16
+	TZCorrection int32 // In seconds, added to local time to get UTC, e.g. -3600 for GMT+1
+	SigFigs      uint32
+	MaxLen       uint32
+	LinkType     Link
+	TSResolution time.Duration  // Either time.Microsecond or time.Nanosecond
+	Recover      bool           // If set, skip over corrupt records instead of failing
+	Skipped      []SkippedRange // The bytes skipped in recovery mode
+	src          *bufio.Reader
+	flipped      bool
+	offset       int64         // The offset of the next record from the start of the file
+	lastTS       time.Duration // The timestamp of the last packet read
+	seen         bool          // Whether any packets have been read yet
+}
+
+// NewReader creates a Reader from anything that implements the io.Reader interface. It reads and
+// validates the pcap file header before returning. Files compressed with gzip, bzip2 or zlib are
+// decompressed transparently. If an error is encountered, the returned Reader contains as much of
+// the header as could be parsed.
+func NewReader(src io.Reader) (*Reader, error) {
+	r := new(Reader)
+
+	// If the file is compressed, read through a decompressor.
+	buffered, err := decompress(src)
+	r.src = buffered
+	if err != nil {
+		return r, err
+	}
+
+	// Check whether this is a libpcap file at all, and if so what byte ordering it has.
+	_, flipped, resolution, err := checkMagicNum(r.src)
+	if err != nil {
+		return r, err
+	}
+	r.flipped = flipped
+	r.TSResolution = resolution
+
+	// Then populate the file header.
+	file := new(PcapFile)
+	err = populateFileHeader(file, r.src, flipped)
+	r.setHeader(file)
+	r.offset = 24
+
+	return r, err
+}
+
+// Next reads and parses the next packet from the file. When there are no more packets it returns
+// io.EOF. If the file ends partway through a packet it returns UnexpectedEOF instead. If any other
+// error is returned, the Packet contains as much data as could be parsed.
+func (r *Reader) Next() (Packet, error) {
+	pkt, data, err := r.ReadRecord()
+	if err != nil {
+		return pkt, err
+	}
+
+	pkt.Data, err = parseLinkData(data, r.LinkType)
+	return pkt, err
+}
+
+// ReadRecord reads the next packet record from the file without parsing the packet data. It returns
+// the packet header, with a nil Data field, and the raw captured bytes. Errors are as for Next, except
+// that if the file ends partway through the packet data, the bytes that could be read are returned
+// along with UnexpectedEOF.
+func (r *Reader) ReadRecord() (Packet, []byte, error) {
+	if r.Recover {
+		r.resync()
+	}
+
+	pkt := new(Packet)
+	data, err := readRecord(pkt, r.src, r.flipped, r.TSResolution)
+	pkt.Time = absoluteTime(pkt.Timestamp, r.TZCorrection)
+
+	if err == nil {
+		r.offset += 16 + int64(len(data))
+		r.lastTS = pkt.Timestamp
+		r.seen = true
+	}
+
+	return *pkt, data, err
+}
+
+// setHeader copies the file header fields out of a PcapFile.
+func (r *Reader) setHeader(file *PcapFile) {
+	r.MajorVersion = file.MajorVersion
+	r.MinorVersion = file.MinorVersion
+	r.TZCorrection = file.TZCorrection
+	r.SigFigs = file.SigFigs
+	r.MaxLen = file.MaxLen
+	r.LinkType = file.LinkType
+}
+
+// header returns a PcapFile containing the file header fields of the Reader, and no packets.
+func (r *Reader) header() PcapFile {
+	return PcapFile{
+		MajorVersion: r.MajorVersion,
+		MinorVersion: r.MinorVersion,
+		TZCorrection: r.TZCorrection,
+		SigFigs:      r.SigFigs,
+		MaxLen:       r.MaxLen,
+		LinkType:     r.LinkType,
+		TSResolution: r.TSResolution,
+	}
+}
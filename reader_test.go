@@ -0,0 +1,144 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	r, err := NewReader(src)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Check the file header.
+	if r.MajorVersion != uint16(2) {
+		t.Errorf("Incorrectly parsed major version: expected %v, got %v.", 2, r.MajorVersion)
+	}
+	if r.MinorVersion != uint16(4) {
+		t.Errorf("Incorrectly parsed minor version: expected %v, got %v.", 4, r.MinorVersion)
+	}
+	if r.MaxLen != uint32(65535) {
+		t.Errorf("Incorrectly parsed maximum len: expected %v, got %v.", 65535, r.MaxLen)
+	}
+	if r.LinkType != ETHERNET {
+		t.Errorf("Incorrect link type: expected %v, got %v.", ETHERNET, r.LinkType)
+	}
+	if r.TSResolution != time.Microsecond {
+		t.Errorf("Incorrect timestamp resolution: expected %v, got %v.", time.Microsecond, r.TSResolution)
+	}
+
+	// The first packet should be the same one Parse finds.
+	pkt, err := r.Next()
+	correct_ts := 321259*time.Hour + 31*time.Minute + 6*time.Second + 654*time.Millisecond + 692*time.Microsecond
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if pkt.Timestamp != correct_ts {
+		t.Errorf("Unexpected TS: expected %v, got %v.", correct_ts, pkt.Timestamp)
+	}
+	if !pkt.Time.Equal(time.Unix(0, int64(correct_ts))) || pkt.Time.Location() != time.UTC {
+		t.Errorf("Unexpected time: expected %v, got %v.", time.Unix(0, int64(correct_ts)).UTC(), pkt.Time)
+	}
+	if _, ok := pkt.Data.(*EthernetFrame); !ok {
+		t.Errorf("Unexpected link layer: %v", pkt.Data)
+	}
+
+	// Read the rest of the file.
+	count := 1
+	for {
+		_, err = r.Next()
+		if err != nil {
+			break
+		}
+		count++
+	}
+
+	if err != io.EOF {
+		t.Errorf("Unexpected error: expected %v, got %v.", io.EOF, err)
+	}
+	if count != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, count)
+	}
+}
+
+func TestReaderNotPcap(t *testing.T) {
+	in := byteReader{0xd4, 0xc3, 0xb2, 0xa0}
+	_, err := NewReader(in)
+
+	if err != NotAPcapFile {
+		t.Errorf("Unexpected error: expected %v, got %v", NotAPcapFile, err)
+	}
+}
+
+// Readers that return partial reads, like pipes and sockets, should parse exactly the same as files.
+func TestReaderShortReads(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	sources := []io.Reader{
+		iotest.OneByteReader(bytes.NewReader(original)),
+		iotest.HalfReader(bytes.NewReader(original)),
+		iotest.DataErrReader(bytes.NewReader(original)),
+	}
+
+	for _, src := range sources {
+		parsed, err := Parse(src)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if len(parsed.Packets) != 2263 {
+			t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, len(parsed.Packets))
+		}
+	}
+}
+
+// A file that ends partway through a record should give UnexpectedEOF, not io.EOF.
+func TestReaderTruncated(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	// The first record is 16 bytes of header and 96 bytes of data, starting after the 24 byte
+	// file header. Cut the file in the data, in the header, and cleanly after the record.
+	lengths := []int{24 + 16 + 50, 24 + 16 + 96 + 8, 24 + 16 + 96}
+	errs := []error{UnexpectedEOF, UnexpectedEOF, io.EOF}
+	counts := []int{0, 1, 1}
+
+	for i, length := range lengths {
+		r, err := NewReader(bytes.NewReader(original[:length]))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		count := 0
+		for {
+			_, err = r.Next()
+			if err != nil {
+				break
+			}
+			count++
+		}
+
+		if err != errs[i] {
+			t.Errorf("Unexpected error: expected %v, got %v.", errs[i], err)
+		}
+		if count != counts[i] {
+			t.Errorf("Unexpected number of packets: expected %v, got %v.", counts[i], count)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package gopcap
+
+import (
+	"bufio"
+	"bytes"
+	"time"
+)
+
+// The limits used to decide whether a record header is plausible when a Reader is in recovery mode.
+// Packets are allowed to go back in time a little, as captures taken on several CPUs often do, but
+// not by more than recoverySlack. A gap of more than recoveryMaxGap between packets is assumed to
+// be corruption.
+const (
+	recoveryMaxLen uint32        = 262144
+	recoverySlack  time.Duration = time.Minute
+	recoveryMaxGap time.Duration = 24 * time.Hour
+)
+
+// SkippedRange is a run of bytes that a Reader in recovery mode skipped over because they didn't look
+// like packet records. Offsets are measured from the start of the (decompressed) file.
+type SkippedRange struct {
+	Offset int64
+	Length int64
+}
+
+// resync makes sure the Reader is positioned at a plausible packet record, discarding bytes one at a
+// time until it is. Any bytes discarded are recorded in Skipped. If no plausible record is found,
+// everything up to the end of the file is discarded.
+//
+// While scanning, a record only counts as plausible if the header after it is plausible too. That
+// check isn't made on the record the Reader is already positioned at, as it would throw away a good
+// record whenever the one after it is corrupt.
+func (r *Reader) resync() {
+	// We need to be able to see a whole record, and the header of the one after it.
+	size := int(r.maxRecordLen()) + 48
+	if r.src.Size() < size {
+		r.src = bufio.NewReaderSize(r.src, size)
+	}
+
+	skipped := int64(0)
+	for !r.plausibleRecord(skipped > 0) {
+		discarded, err := r.src.Discard(1)
+		skipped += int64(discarded)
+		if err != nil {
+			break
+		}
+	}
+
+	if skipped > 0 {
+		r.Skipped = append(r.Skipped, SkippedRange{Offset: r.offset, Length: skipped})
+		r.offset += skipped
+	}
+}
+
+// plausibleRecord checks whether the next bytes in the file look like a valid packet record. The
+// header must have sensible lengths and a timestamp close to the previous packet's, the packet data
+// must start with a sensible link-layer header, and if chained is set the record must be followed
+// either by the end of the file or by another plausible header. Running into the end of the file, or
+// an error, counts as plausible: it's left to the normal reading code to report.
+func (r *Reader) plausibleRecord(chained bool) bool {
+	header, _ := r.src.Peek(16)
+	if len(header) == 0 {
+		return true
+	} else if len(header) < 16 {
+		return false
+	}
+
+	pkt := new(Packet)
+	if !r.plausibleHeader(pkt, header, r.lastTS, r.seen) {
+		return false
+	}
+
+	record, _ := r.src.Peek(16 + int(pkt.IncludedLen) + 16)
+	if len(record) < 16+int(pkt.IncludedLen) {
+		return true
+	}
+	if !plausibleLinkData(record[16:16+int(pkt.IncludedLen)], r.LinkType) {
+		return false
+	} else if !chained {
+		return true
+	}
+
+	// A partial header after the record just means the file was cut short.
+	next := record[16+int(pkt.IncludedLen):]
+	if len(next) < 16 {
+		return true
+	}
+	return r.plausibleHeader(new(Packet), next, pkt.Timestamp, true)
+}
+
+// plausibleHeader parses a record header into pkt, and checks that its fields are sensible. If
+// hasPrevious is set, the timestamp must also be close to previous.
+func (r *Reader) plausibleHeader(pkt *Packet, header []byte, previous time.Duration, hasPrevious bool) bool {
+	// The header is all there, so this can't fail.
+	populatePacketHeader(pkt, bytes.NewReader(header), r.flipped, r.TSResolution)
+
+	if getUint32(header[4:8], r.flipped) >= uint32(time.Second/r.TSResolution) {
+		return false
+	}
+	if pkt.IncludedLen > r.maxRecordLen() || pkt.IncludedLen > pkt.ActualLen || pkt.ActualLen > recoveryMaxLen {
+		return false
+	}
+	if hasPrevious && (pkt.Timestamp < previous-recoverySlack || pkt.Timestamp > previous+recoveryMaxGap) {
+		return false
+	}
+
+	return true
+}
+
+// maxRecordLen returns the largest IncludedLen a plausible record can have.
+func (r *Reader) maxRecordLen() uint32 {
+	return maxRecordLen(r.MaxLen)
+}
+
+// maxRecordLen returns the largest IncludedLen a plausible record can have in a file with the given
+// MaxLen.
+func maxRecordLen(maxLen uint32) uint32 {
+	if maxLen == 0 || maxLen > recoveryMaxLen {
+		return recoveryMaxLen
+	}
+	return maxLen
+}
+
+// plausibleLinkData checks that packet data starts with a sensible link-layer header. Only Ethernet is
+// checked: data for any other link type is always plausible.
+func plausibleLinkData(data []byte, linkType Link) bool {
+	if linkType != ETHERNET {
+		return true
+	}
+
+	if len(data) < 14 {
+		return false
+	}
+
+	// The EtherType field holds either a length of at most 1500 bytes or an actual EtherType.
+	etherType := EtherType(getUint16(data[12:14], false))
+	if etherType > 1500 && etherType < EtherType(minEtherType) {
+		return false
+	}
+
+	// If it claims to be IP, the version had better match.
+	if etherType == ETHERTYPE_IPV4 && len(data) > 14 && data[14]>>4 != 4 {
+		return false
+	} else if etherType == ETHERTYPE_IPV6 && len(data) > 14 && data[14]>>4 != 6 {
+		return false
+	}
+
+	return true
+}
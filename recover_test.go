@@ -0,0 +1,139 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// recoverTestFile builds a pcap file of ten copies of the test frame, one second apart. It returns
+// the file and the offset of each record in it.
+func recoverTestFile() ([]byte, []int) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, PcapFile{MaxLen: 65535, LinkType: ETHERNET}, true)
+
+	offsets := make([]int, 0)
+	for i := 0; i < 10; i++ {
+		offsets = append(offsets, buf.Len())
+		w.WritePacket(Packet{Timestamp: 1415620800*time.Second + time.Duration(i)*time.Second}, ngTestFrame)
+	}
+
+	return buf.Bytes(), offsets
+}
+
+// readAll reads every packet from a Reader, returning them along with the error that stopped it.
+func readAll(r *Reader) ([]Packet, error) {
+	packets := make([]Packet, 0)
+	for {
+		pkt, err := r.Next()
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, pkt)
+	}
+}
+
+func TestRecoverCorruptHeader(t *testing.T) {
+	file, offsets := recoverTestFile()
+
+	// Give the fourth record an absurd included length.
+	putUint32(file[offsets[3]+8:offsets[3]+12], 0x00fffff0, true)
+
+	// Without recovery, reading fails at the bad record.
+	r, _ := NewReader(bytes.NewReader(file))
+	packets, err := readAll(r)
+	if len(packets) != 3 || err != UnexpectedEOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 3, UnexpectedEOF, len(packets), err)
+	}
+
+	// With recovery, the bad record is skipped.
+	r, _ = NewReader(bytes.NewReader(file))
+	r.Recover = true
+	packets, err = readAll(r)
+	if len(packets) != 9 || err != io.EOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 9, io.EOF, len(packets), err)
+	}
+
+	expected := []SkippedRange{{Offset: int64(offsets[3]), Length: int64(offsets[4] - offsets[3])}}
+	if len(r.Skipped) != 1 || r.Skipped[0] != expected[0] {
+		t.Errorf("Unexpected skipped ranges: expected %v, got %v.", expected, r.Skipped)
+	}
+
+	for i, pkt := range packets {
+		if _, ok := pkt.Data.(*EthernetFrame); !ok {
+			t.Errorf("Unexpected link layer in packet %v: %v", i, pkt.Data)
+		}
+	}
+	if packets[3].Timestamp != 1415620804*time.Second {
+		t.Errorf("Unexpected TS: expected %v, got %v.", 1415620804*time.Second, packets[3].Timestamp)
+	}
+}
+
+// A header that looks fine on its own, but has the wrong length, can't be told apart from a
+// truncated packet. The record is returned short, and the rest of its data is skipped.
+func TestRecoverWrongLength(t *testing.T) {
+	file, offsets := recoverTestFile()
+	putUint32(file[offsets[5]+8:offsets[5]+12], 60, true)
+
+	r, _ := NewReader(bytes.NewReader(file))
+	r.Recover = true
+	packets, err := readAll(r)
+	if len(packets) != 10 || err != io.EOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 10, io.EOF, len(packets), err)
+	}
+	if packets[5].IncludedLen != 60 {
+		t.Errorf("Unexpected included length: expected %v, got %v.", 60, packets[5].IncludedLen)
+	}
+
+	expected := SkippedRange{Offset: int64(offsets[5] + 16 + 60), Length: int64(len(ngTestFrame) - 60)}
+	if len(r.Skipped) != 1 || r.Skipped[0] != expected {
+		t.Errorf("Unexpected skipped ranges: expected %v, got %v.", expected, r.Skipped)
+	}
+}
+
+func TestRecoverGarbage(t *testing.T) {
+	file, offsets := recoverTestFile()
+
+	// Insert some junk between two records, and more at the end of the file.
+	junk := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef, 0x00}, 20)
+	corrupt := append([]byte{}, file[:offsets[7]]...)
+	corrupt = append(corrupt, junk...)
+	corrupt = append(corrupt, file[offsets[7]:]...)
+	corrupt = append(corrupt, junk[:10]...)
+
+	r, _ := NewReader(bytes.NewReader(corrupt))
+	r.Recover = true
+	packets, err := readAll(r)
+	if len(packets) != 10 || err != io.EOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 10, io.EOF, len(packets), err)
+	}
+
+	expected := []SkippedRange{
+		{Offset: int64(offsets[7]), Length: int64(len(junk))},
+		{Offset: int64(len(file) + len(junk)), Length: 10},
+	}
+	if len(r.Skipped) != 2 || r.Skipped[0] != expected[0] || r.Skipped[1] != expected[1] {
+		t.Errorf("Unexpected skipped ranges: expected %v, got %v.", expected, r.Skipped)
+	}
+}
+
+// Recovery mode shouldn't skip anything in a good file.
+func TestRecoverCleanFile(t *testing.T) {
+	src, err := os.Open("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+	defer src.Close()
+
+	r, _ := NewReader(src)
+	r.Recover = true
+	packets, err := readAll(r)
+	if len(packets) != 2263 || err != io.EOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", 2263, io.EOF, len(packets), err)
+	}
+	if len(r.Skipped) != 0 {
+		t.Errorf("Unexpected skipped ranges: %v.", r.Skipped)
+	}
+}
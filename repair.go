@@ -0,0 +1,145 @@
+package gopcap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// RepairReport describes what Repair had to fix to produce a clean file.
+type RepairReport struct {
+	HeaderRepaired bool           // Whether any of the file header had to be rebuilt
+	Packets        int            // The number of packets written
+	Trimmed        int            // The number of packets cut short because the file ended partway through them
+	Dropped        int            // The number of packet records dropped because none of their data survived
+	Skipped        []SkippedRange // The bytes skipped over because they didn't look like packet records
+}
+
+// Repair reads a damaged .pcap file from src and writes a clean copy of it to dst. The file header is
+// rebuilt if it is damaged: a missing magic number is replaced, guessing the byte order and timestamp
+// resolution from the rest of the file, and implausible versions, lengths, link types and time zones
+// are replaced with defaults. Corrupt records are skipped as a Reader in recovery mode would, and a
+// record cut short by the end of the file is trimmed to the data that is present. The returned report
+// describes what was fixed. An error is returned only if the file can't be repaired at all, or if
+// reading or writing fails.
+func Repair(src io.Reader, dst io.Writer) (RepairReport, error) {
+	report := RepairReport{Skipped: make([]SkippedRange, 0)}
+
+	buffered, err := decompress(src)
+	if err != nil {
+		return report, err
+	}
+
+	header := make([]byte, 24)
+	_, err = io.ReadFull(buffered, header)
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return report, InsufficientLength
+	} else if err != nil {
+		return report, err
+	}
+
+	// A pcapng file isn't a damaged pcap file, and there's no sense trying to repair it as one.
+	if getUint32(header[0:4], false) == ngBlockSectionHeader {
+		return report, NotAPcapFile
+	}
+
+	r := &Reader{src: buffered, Recover: true, offset: 24}
+	_, flipped, resolution, err := checkMagicNum(bytes.NewReader(header[0:4]))
+	if err != nil {
+		report.HeaderRepaired = true
+		flipped = guessByteOrder(header)
+		resolution = guessResolution(buffered, flipped)
+	}
+	r.flipped = flipped
+	r.TSResolution = resolution
+
+	file := new(PcapFile)
+	populateFileHeader(file, bytes.NewReader(header[4:]), flipped)
+	if repairFileHeader(file) {
+		report.HeaderRepaired = true
+	}
+	r.setHeader(file)
+
+	w, err := NewWriter(dst, r.header(), flipped)
+	if err != nil {
+		return report, err
+	}
+
+	for {
+		pkt, data, err := r.ReadRecord()
+		report.Skipped = r.Skipped
+
+		if err == io.EOF {
+			return report, nil
+		} else if err == UnexpectedEOF && len(data) == 0 {
+			report.Dropped++
+			return report, nil
+		} else if err == UnexpectedEOF {
+			report.Trimmed++
+		} else if err != nil {
+			return report, err
+		}
+
+		err = w.WritePacket(pkt, data)
+		if err != nil {
+			return report, err
+		}
+		report.Packets++
+	}
+}
+
+// guessByteOrder works out the byte order of a file header with a damaged magic number, by looking
+// for a version or link type that makes sense in one byte order but not the other. Little-endian is
+// assumed if there's nothing to go on, as that's what most capture tools write.
+func guessByteOrder(header []byte) bool {
+	if getUint16(header[4:6], false) == 2 {
+		return false
+	} else if getUint16(header[4:6], true) == 2 {
+		return true
+	} else if getUint32(header[20:24], true) <= 0xffff {
+		return true
+	} else if getUint32(header[20:24], false) <= 0xffff {
+		return false
+	}
+
+	return true
+}
+
+// guessResolution works out the timestamp resolution of a file with a damaged magic number. The
+// sub-second part of a microsecond timestamp is always less than a million, so a larger value in the
+// first record means the file has nanosecond timestamps.
+func guessResolution(src *bufio.Reader, flipped bool) time.Duration {
+	record, _ := src.Peek(16)
+	if len(record) == 16 && getUint32(record[4:8], flipped) >= 1000000 {
+		return time.Nanosecond
+	}
+
+	return time.Microsecond
+}
+
+// repairFileHeader replaces any implausible fields in a file header with defaults. It returns whether
+// anything was changed.
+func repairFileHeader(file *PcapFile) bool {
+	repaired := false
+
+	if file.MajorVersion != 2 {
+		file.MajorVersion = 2
+		file.MinorVersion = 4
+		repaired = true
+	}
+	if file.TZCorrection < -86400 || file.TZCorrection > 86400 {
+		file.TZCorrection = 0
+		repaired = true
+	}
+	if file.MaxLen == 0 || file.MaxLen > recoveryMaxLen {
+		file.MaxLen = recoveryMaxLen
+		repaired = true
+	}
+	if file.LinkType > 0xffff {
+		file.LinkType = ETHERNET
+		repaired = true
+	}
+
+	return repaired
+}
@@ -0,0 +1,111 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// repairCheck reads back a repaired file, checking that it is clean and has the expected number of
+// packets.
+func repairCheck(t *testing.T, repaired []byte, count int) *Reader {
+	r, err := NewReader(bytes.NewReader(repaired))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	packets, err := readAll(r)
+	if len(packets) != count || err != io.EOF {
+		t.Errorf("Unexpected result: expected %v packets and %v, got %v packets and %v.", count, io.EOF, len(packets), err)
+	}
+	return r
+}
+
+func TestRepairCleanFile(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	buf := new(bytes.Buffer)
+	report, err := Repair(bytes.NewReader(original), buf)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if report.HeaderRepaired || report.Packets != 2263 || report.Trimmed != 0 || report.Dropped != 0 || len(report.Skipped) != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if bytes.Compare(buf.Bytes(), original) != 0 {
+		t.Errorf("Repaired file differs from the original.")
+	}
+}
+
+func TestRepairHeader(t *testing.T) {
+	file, _ := recoverTestFile()
+
+	// Wipe out the magic number and the link type.
+	copy(file[0:4], []byte{0, 0, 0, 0})
+	putUint32(file[20:24], 0xdeadbeef, true)
+
+	buf := new(bytes.Buffer)
+	report, err := Repair(bytes.NewReader(file), buf)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !report.HeaderRepaired || report.Packets != 10 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	r := repairCheck(t, buf.Bytes(), 10)
+	if !r.flipped || r.TSResolution != time.Microsecond || r.LinkType != ETHERNET || r.MaxLen != 65535 {
+		t.Errorf("Unexpected header: %+v", r.header())
+	}
+}
+
+func TestRepairNanosecondHeader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, PcapFile{LinkType: ETHERNET, TSResolution: time.Nanosecond}, false)
+	w.WritePacket(Packet{Timestamp: time.Hour + 999999999*time.Nanosecond}, ngTestFrame)
+
+	file := buf.Bytes()
+	copy(file[0:4], []byte{0xff, 0xff, 0xff, 0xff})
+
+	repaired := new(bytes.Buffer)
+	_, err := Repair(bytes.NewReader(file), repaired)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	r := repairCheck(t, repaired.Bytes(), 1)
+	if r.flipped || r.TSResolution != time.Nanosecond {
+		t.Errorf("Unexpected header: %+v", r.header())
+	}
+}
+
+func TestRepairDamagedRecords(t *testing.T) {
+	file, offsets := recoverTestFile()
+
+	// Corrupt one record header, and cut the file off partway through the last packet.
+	putUint32(file[offsets[2]+8:offsets[2]+12], 0x00fffff0, true)
+	file = file[:len(file)-20]
+
+	buf := new(bytes.Buffer)
+	report, err := Repair(bytes.NewReader(file), buf)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if report.HeaderRepaired || report.Packets != 9 || report.Trimmed != 1 || report.Dropped != 0 || len(report.Skipped) != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	repairCheck(t, buf.Bytes(), 9)
+}
+
+func TestRepairTruncatedHeader(t *testing.T) {
+	_, err := Repair(bytes.NewReader([]byte{0xd4, 0xc3, 0xb2, 0xa1}), new(bytes.Buffer))
+	if err != InsufficientLength {
+		t.Errorf("Unexpected error: expected %v, got %v", InsufficientLength, err)
+	}
+}
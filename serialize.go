@@ -0,0 +1,65 @@
+package gopcap
+
+import (
+	"errors"
+)
+
+// Errors
+var InvalidField error = errors.New("Invalid field value.")
+var NotSerializable error = errors.New("Layer can't be serialized.")
+
+// SerializeOptions controls how layers are turned back into bytes.
+type SerializeOptions struct {
+	// If FixLengths is set, length fields are recomputed from the contents of each layer instead of
+	// being written as they are: the IPv4 IHL and TotalLength, the IPv6 payload Length, the TCP
+	// HeaderSize and the UDP Length. Options are padded to a whole number of 32-bit words.
+	FixLengths bool
+
+	// If ComputeChecksums is set, the IPv4 header checksum and the TCP and UDP checksums are
+	// recomputed. The TCP and UDP checksums cover the addresses of the IP packet containing them, so
+	// they are only recomputed when serializing the IP packet, and not for a packet that was
+	// truncated when it was captured.
+	ComputeChecksums bool
+}
+
+// Serializable is implemented by layers that can be turned back into the bytes sent on the wire.
+// AppendBytes appends the layer, along with all the layers it contains, to b and returns the
+// extended slice. Any fields recomputed because of the options are updated in the layer as well.
+// Every layer gopcap decodes is Serializable.
+type Serializable interface {
+	AppendBytes(b []byte, opts SerializeOptions) ([]byte, error)
+}
+
+// ToBytes returns the wire form of a link, internet or transport layer, along with all the layers
+// it contains. A decoded Packet can be turned back into bytes by calling ToBytes on its Data. If the
+// layer, or any layer it contains, isn't Serializable, ToBytes returns NotSerializable.
+func ToBytes(layer interface{}, opts SerializeOptions) ([]byte, error) {
+	if layer == nil {
+		return nil, NotSerializable
+	}
+	return appendLayer(make([]byte, 0, 128), layer, opts)
+}
+
+// appendLayer appends a layer contained in another to b. A missing layer appends nothing.
+func appendLayer(b []byte, layer interface{}, opts SerializeOptions) ([]byte, error) {
+	if layer == nil {
+		return b, nil
+	}
+
+	s, ok := layer.(Serializable)
+	if !ok {
+		return b, NotSerializable
+	}
+	return s.AppendBytes(b, opts)
+}
+
+// padOptions pads IP or TCP options with zeros to a whole number of 32-bit words.
+func padOptions(options []byte) []byte {
+	if len(options)%4 == 0 {
+		return options
+	}
+
+	padded := make([]byte, len(options)+4-len(options)%4)
+	copy(padded, options)
+	return padded
+}
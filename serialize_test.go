@@ -0,0 +1,218 @@
+package gopcap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// Re-serializing every packet in the test file should give back the original bytes, apart from any
+// Ethernet padding, which isn't part of any layer.
+func TestSerializeRoundTrip(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	r, _ := NewReader(bytes.NewReader(original))
+	for i := 0; ; i++ {
+		_, data, err := r.ReadRecord()
+		if err != nil {
+			break
+		}
+
+		link, _ := parseLinkData(data, r.LinkType)
+		out, err := ToBytes(link, SerializeOptions{})
+		if err != nil {
+			t.Errorf("Unexpected error for packet %v: %v", i, err)
+		}
+		if !bytes.HasPrefix(data, out) || len(data)-len(out) > 46 {
+			t.Errorf("Unexpected bytes for packet %v: expected %x, got %x.", i, data, out)
+		}
+	}
+}
+
+// Recomputing the checksums should only change the checksum fields. The test frame was captured on
+// the sending host with checksum offload, so its TCP checksum is wrong to start with.
+func TestSerializeChecksums(t *testing.T) {
+	link, _ := parseLinkData(ngTestFrame, ETHERNET)
+	ip := link.LinkData().(*IPv4Packet)
+	tcp := ip.InternetData().(*TCPSegment)
+
+	ip.Checksum = 0
+	out, err := ToBytes(link, SerializeOptions{ComputeChecksums: true})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if ip.Checksum != 0x56CF {
+		t.Errorf("Unexpected IPv4 checksum: expected %v, got %v.", 0x56CF, ip.Checksum)
+	}
+	if tcp.Checksum != 0x411B {
+		t.Errorf("Unexpected TCP checksum: expected %v, got %v.", 0x411B, tcp.Checksum)
+	}
+	if bytes.Compare(out[:50], ngTestFrame[:50]) != 0 || bytes.Compare(out[52:], ngTestFrame[52:]) != 0 {
+		t.Errorf("Unexpected bytes: expected %x, got %x.", ngTestFrame, out)
+	}
+	if foldChecksum(sumBytes(out[34:], pseudoHeaderSum(ip.SourceAddress, ip.DestAddress, IPP_TCP, len(out)-34))) != 0 {
+		t.Errorf("Bad TCP checksum: %v", tcp.Checksum)
+	}
+}
+
+// Layers built from scratch should come out with correct lengths and checksums.
+func TestSerializeFixLengths(t *testing.T) {
+	udp := &UDPDatagram{SourcePort: 5353, DestinationPort: 53, data: []byte("hello")}
+	ip := &IPv4Packet{
+		TTL:           64,
+		Protocol:      IPP_UDP,
+		SourceAddress: []byte{10, 0, 0, 1},
+		DestAddress:   []byte{10, 0, 0, 2},
+		Options:       []byte{0x94, 0x04},
+		data:          udp,
+	}
+	frame := &EthernetFrame{
+		MACDestination: []byte{0, 1, 2, 3, 4, 5},
+		MACSource:      []byte{6, 7, 8, 9, 10, 11},
+		EtherType:      ETHERTYPE_IPV4,
+		data:           ip,
+	}
+
+	out, err := ToBytes(frame, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(out) != 14+24+8+5 {
+		t.Errorf("Unexpected length: expected %v, got %v.", 14+24+8+5, len(out))
+	}
+	if ip.IHL != 6 || ip.TotalLength != 37 || udp.Length != 13 {
+		t.Errorf("Unexpected lengths: IHL %v, total length %v, UDP length %v.", ip.IHL, ip.TotalLength, udp.Length)
+	}
+
+	// The checksums should verify.
+	if foldChecksum(sumBytes(out[14:38], 0)) != 0 {
+		t.Errorf("Bad IPv4 header checksum: %v", ip.Checksum)
+	}
+	if foldChecksum(sumBytes(out[38:], pseudoHeaderSum(ip.SourceAddress, ip.DestAddress, IPP_UDP, 13))) != 0 {
+		t.Errorf("Bad UDP checksum: %v", udp.Checksum)
+	}
+
+	// And it should decode to the same thing.
+	decoded, _ := parseLinkData(out, ETHERNET)
+	dgram := decoded.LinkData().InternetData().(*UDPDatagram)
+	if dgram.SourcePort != 5353 || dgram.Checksum != udp.Checksum || string(dgram.TransportData()) != "hello" {
+		t.Errorf("Unexpected datagram: %+v", dgram)
+	}
+}
+
+func TestSerializeIPv6(t *testing.T) {
+	tcp := &TCPSegment{SourcePort: 80, DestinationPort: 1024, SYN: true, ACK: true, NS: true, OptionData: []byte{0x02, 0x04, 0x05, 0xB4}}
+	ip := &IPv6Packet{
+		TrafficClass:       0xB8,
+		FlowLabel:          0xABCDE,
+		NextHeader:         IPP_TCP,
+		HopLimit:           64,
+		SourceAddress:      bytes.Repeat([]byte{0x20}, 16),
+		DestinationAddress: bytes.Repeat([]byte{0xFE}, 16),
+		data:               tcp,
+	}
+
+	out, err := ToBytes(ip, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if foldChecksum(sumBytes(out[40:], pseudoHeaderSum(ip.SourceAddress, ip.DestinationAddress, IPP_TCP, 24))) != 0 {
+		t.Errorf("Bad TCP checksum: %v", tcp.Checksum)
+	}
+
+	decoded := new(IPv6Packet)
+	err = decoded.FromBytes(out)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if decoded.TrafficClass != 0xB8 || decoded.FlowLabel != 0xABCDE || decoded.Length != 24 {
+		t.Errorf("Unexpected header: %+v", decoded)
+	}
+
+	segment := decoded.InternetData().(*TCPSegment)
+	if !segment.SYN || !segment.ACK || !segment.NS || segment.FIN || segment.HeaderSize != 6 {
+		t.Errorf("Unexpected segment: %+v", segment)
+	}
+}
+
+// The transport checksum covers the whole datagram, so it's left alone in a packet that holds only
+// part of it, or that isn't yet at its final destination.
+func TestSerializeFragmentChecksums(t *testing.T) {
+	udp := &UDPDatagram{SourcePort: 5353, DestinationPort: 53, Length: 1000, Checksum: 0x4F43, data: []byte("hello")}
+	ip := &IPv4Packet{
+		TTL:           64,
+		Protocol:      IPP_UDP,
+		MoreFragments: true,
+		SourceAddress: []byte{10, 0, 0, 1},
+		DestAddress:   []byte{10, 0, 0, 2},
+		data:          udp,
+	}
+	_, err := ToBytes(ip, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if err != nil || udp.Checksum != 0x4F43 {
+		t.Errorf("Unexpected UDP checksum: expected %v, got %v (%v).", 0x4F43, udp.Checksum, err)
+	}
+
+	headers := [][]IPv6ExtensionHeader{
+		{&IPv6Fragment{NextHeader: IPP_UDP, MoreFragments: true, ID: 1}},
+		{&IPv6Routing{NextHeader: IPP_UDP, SegmentsLeft: 1, Data: make([]byte, 20)}},
+	}
+	for i, extensions := range headers {
+		udp.Checksum = 0x4F43
+		ip6 := &IPv6Packet{
+			NextHeader:         extensions[0].HeaderType(),
+			HopLimit:           64,
+			SourceAddress:      bytes.Repeat([]byte{0x20}, 16),
+			DestinationAddress: bytes.Repeat([]byte{0xFE}, 16),
+			ExtensionHeaders:   extensions,
+			data:               udp,
+		}
+		_, err = ToBytes(ip6, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+		if err != nil || udp.Checksum != 0x4F43 {
+			t.Errorf("Unexpected UDP checksum for packet %v: expected %v, got %v (%v).", i, 0x4F43, udp.Checksum, err)
+		}
+	}
+
+	// Once the packet is whole, the checksum is recomputed as usual.
+	ip.MoreFragments = false
+	ToBytes(ip, SerializeOptions{FixLengths: true, ComputeChecksums: true})
+	if udp.Checksum == 0x4F43 {
+		t.Errorf("UDP checksum wasn't recomputed.")
+	}
+}
+
+func TestSerializeInvalid(t *testing.T) {
+	ip := &IPv4Packet{SourceAddress: []byte{1, 2, 3}, DestAddress: []byte{1, 2, 3, 4}}
+	_, err := ToBytes(ip, SerializeOptions{})
+	if err != InvalidField {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidField, err)
+	}
+
+	tcp := &TCPSegment{OptionData: make([]byte, 41)}
+	_, err = ToBytes(tcp, SerializeOptions{FixLengths: true})
+	if err != InvalidField {
+		t.Errorf("Unexpected error: expected %v, got %v", InvalidField, err)
+	}
+}
+
+// notSerializable is a transport layer from outside gopcap that can't be turned back into bytes.
+type notSerializable struct{}
+
+func (n notSerializable) TransportData() []byte       { return nil }
+func (n notSerializable) FromBytes(data []byte) error { return nil }
+
+func TestSerializeNotSerializable(t *testing.T) {
+	ip := &IPv4Packet{SourceAddress: make([]byte, 4), DestAddress: make([]byte, 4), data: notSerializable{}}
+	_, err := ToBytes(ip, SerializeOptions{})
+	if err != NotSerializable {
+		t.Errorf("Unexpected error: expected %v, got %v", NotSerializable, err)
+	}
+
+	_, err = ToBytes(notSerializable{}, SerializeOptions{})
+	if err != NotSerializable {
+		t.Errorf("Unexpected error: expected %v, got %v", NotSerializable, err)
+	}
+}
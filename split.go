@@ -0,0 +1,219 @@
+package gopcap
+
+import (
+	"container/list"
+	"io"
+	"time"
+)
+
+// SplitOptions configures Split. Each non-zero limit starts a new file when it is reached; if more
+// than one is set, whichever is reached first starts the new file.
+type SplitOptions struct {
+	Packets  int           // The maximum number of packets in each file
+	Bytes    int64         // The maximum size of each file, including its headers
+	Interval time.Duration // The length of time covered by each file
+}
+
+// Split reads every packet from r and writes them to a series of pcap files with the same header as
+// the source, starting a new file each time one of the limits in opts is reached. Files are obtained
+// by calling create with their number, counting from zero, and are closed once they are complete. A
+// packet larger than the Bytes limit gets a file of its own.
+//
+// Time intervals start at the time of the first packet, so the first file covers [t, t+Interval),
+// the second [t+Interval, t+2*Interval), and so on. Intervals that contain no packets don't get a
+// file, so the numbering has gaps. Packets that are out of order never start a new file.
+func Split(r *Reader, opts SplitOptions, create func(n int) (io.WriteCloser, error)) error {
+	var file io.WriteCloser
+	var w *Writer
+	var windowStart time.Time
+
+	n := -1
+	packets := 0
+	size := int64(0)
+
+	for {
+		pkt, data, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			closeSplitFile(file)
+			return err
+		}
+
+		recordSize := 16 + int64(len(data))
+
+		// Work out whether this packet needs a new file.
+		rotate := file == nil
+		if opts.Packets > 0 && packets >= opts.Packets {
+			rotate = true
+		}
+		if opts.Bytes > 0 && packets > 0 && size+recordSize > opts.Bytes {
+			rotate = true
+		}
+		if opts.Interval > 0 {
+			if windowStart.IsZero() {
+				windowStart = pkt.Time
+			}
+
+			windows := int(pkt.Time.Sub(windowStart) / opts.Interval)
+			if windows > 0 {
+				windowStart = windowStart.Add(time.Duration(windows) * opts.Interval)
+				n += windows - 1
+				rotate = true
+			}
+		}
+
+		if rotate {
+			err = closeSplitFile(file)
+			if err != nil {
+				return err
+			}
+
+			n++
+			file, err = create(n)
+			if err != nil {
+				return err
+			}
+
+			w, err = NewWriter(file, r.header(), r.flipped)
+			if err != nil {
+				closeSplitFile(file)
+				return err
+			}
+			packets = 0
+			size = 24
+		}
+
+		err = w.WritePacket(pkt, data)
+		if err != nil {
+			closeSplitFile(file)
+			return err
+		}
+		packets++
+		size += recordSize
+	}
+
+	return closeSplitFile(file)
+}
+
+// SplitFlowsOptions configures SplitFlows.
+type SplitFlowsOptions struct {
+	MaxOpenFiles int // The most files to keep open at once. Defaults to 256.
+}
+
+// splitFlowFile is a file that SplitFlows has open.
+type splitFlowFile struct {
+	key  FlowKey
+	file io.WriteCloser
+	w    *Writer
+}
+
+// SplitFlows reads every packet from r and writes each TCP or UDP conversation to its own pcap file,
+// with the same header as the source. Both directions of a conversation go to the same file. Files
+// are obtained by calling create with the conversation's key, as returned by FlowKey.Conversation.
+// Packets that aren't part of a TCP or UDP conversation are written to a file created with the zero
+// FlowKey.
+//
+// Only opts.MaxOpenFiles files are kept open at once: when another is needed, the one that was
+// written to least recently is closed. If a conversation's file has to be opened again, create is
+// called with appending set, and must return a file that appends to what was written before. The
+// header is only written when appending isn't set.
+func SplitFlows(r *Reader, opts SplitFlowsOptions, create func(key FlowKey, appending bool) (io.WriteCloser, error)) error {
+	if opts.MaxOpenFiles <= 0 {
+		opts.MaxOpenFiles = 256
+	}
+
+	open := make(map[FlowKey]*list.Element)
+	order := list.New() // The open files, most recently written first
+	seen := make(map[FlowKey]bool)
+
+	closeOldest := func() error {
+		f := order.Remove(order.Back()).(*splitFlowFile)
+		delete(open, f.key)
+		return f.file.Close()
+	}
+	closeAll := func() error {
+		var firstErr error
+		for order.Len() > 0 {
+			err := closeOldest()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	for {
+		pkt, data, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			closeAll()
+			return err
+		}
+
+		// A packet that can't be decoded just doesn't belong to a flow.
+		pkt.Data, _ = parseLinkData(data, r.LinkType)
+		key, ok := PacketFlow(pkt)
+		if ok {
+			key = key.Conversation()
+		}
+
+		element, ok := open[key]
+		if ok {
+			order.MoveToFront(element)
+		} else {
+			if order.Len() >= opts.MaxOpenFiles {
+				err = closeOldest()
+				if err != nil {
+					closeAll()
+					return err
+				}
+			}
+
+			f := &splitFlowFile{key: key}
+			f.file, err = create(key, seen[key])
+			if err != nil {
+				closeAll()
+				return err
+			}
+			element = order.PushFront(f)
+			open[key] = element
+
+			f.w, err = newSplitWriter(f.file, r, seen[key])
+			if err != nil {
+				closeAll()
+				return err
+			}
+			seen[key] = true
+		}
+
+		err = element.Value.(*splitFlowFile).w.WritePacket(pkt, data)
+		if err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	return closeAll()
+}
+
+// newSplitWriter creates a Writer for a file created by SplitFlows, with the same header as the
+// source. If the file is being appended to, it already has the header, so it isn't written again.
+func newSplitWriter(file io.Writer, r *Reader, appending bool) (*Writer, error) {
+	if !appending {
+		return NewWriter(file, r.header(), r.flipped)
+	}
+
+	w, err := NewWriter(io.Discard, r.header(), r.flipped)
+	w.dst = file
+	return w, err
+}
+
+// closeSplitFile closes a file created by Split, if there is one.
+func closeSplitFile(file io.WriteCloser) error {
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
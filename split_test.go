@@ -0,0 +1,204 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// splitFile is an in-memory file for Split to write to.
+type splitFile struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (f *splitFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+// splitTestReader returns a Reader for the test capture.
+func splitTestReader(t *testing.T) *Reader {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	r, err := NewReader(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return r
+}
+
+// splitRun splits the test capture, returning the files by number.
+func splitRun(t *testing.T, opts SplitOptions) map[int]*splitFile {
+	files := make(map[int]*splitFile)
+	err := Split(splitTestReader(t), opts, func(n int) (io.WriteCloser, error) {
+		files[n] = new(splitFile)
+		return files[n], nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	total := 0
+	for n, file := range files {
+		if !file.closed {
+			t.Errorf("File %v was not closed.", n)
+		}
+		total += len(splitPackets(t, file))
+	}
+	if total != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, total)
+	}
+
+	return files
+}
+
+// splitPackets reads back the packets in a file written by Split.
+func splitPackets(t *testing.T, file *splitFile) []Packet {
+	r, err := NewReader(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	packets, err := readAll(r)
+	if err != io.EOF {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	return packets
+}
+
+func TestSplitPackets(t *testing.T) {
+	files := splitRun(t, SplitOptions{Packets: 1000})
+
+	expected := []int{1000, 1000, 263}
+	if len(files) != len(expected) {
+		t.Fatalf("Unexpected number of files: expected %v, got %v.", len(expected), len(files))
+	}
+	for n, count := range expected {
+		if len(splitPackets(t, files[n])) != count {
+			t.Errorf("Unexpected number of packets in file %v: expected %v, got %v.", n, count, len(splitPackets(t, files[n])))
+		}
+	}
+}
+
+func TestSplitBytes(t *testing.T) {
+	files := splitRun(t, SplitOptions{Bytes: 100000})
+
+	if len(files) != 5 {
+		t.Errorf("Unexpected number of files: expected %v, got %v.", 5, len(files))
+	}
+	for n, file := range files {
+		if file.Len() > 100000 {
+			t.Errorf("File %v is too large: %v bytes.", n, file.Len())
+		}
+	}
+}
+
+func TestSplitInterval(t *testing.T) {
+	files := splitRun(t, SplitOptions{Interval: 10 * time.Second})
+
+	start := splitPackets(t, files[0])[0].Time
+	for n, file := range files {
+		first := splitPackets(t, file)[0].Time
+		windowStart := start.Add(time.Duration(n) * 10 * time.Second)
+		if first.Before(windowStart) || !first.Before(windowStart.Add(10*time.Second)) {
+			t.Errorf("Unexpected first packet in file %v: %v is outside [%v, %v).", n, first, windowStart, windowStart.Add(10*time.Second))
+		}
+	}
+}
+
+func TestSplitFlows(t *testing.T) {
+	files := make(map[FlowKey]*splitFile)
+	err := SplitFlows(splitTestReader(t), SplitFlowsOptions{}, func(key FlowKey, appending bool) (io.WriteCloser, error) {
+		if _, ok := files[key]; ok || appending {
+			t.Errorf("File for %v created twice.", key)
+		}
+		files[key] = new(splitFile)
+		return files[key], nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	total := 0
+	for key, file := range files {
+		if !file.closed {
+			t.Errorf("File for %v was not closed.", key)
+		}
+
+		packets := splitPackets(t, file)
+		total += len(packets)
+
+		// Every packet in a conversation's file should belong to that conversation.
+		for _, pkt := range packets {
+			flow, ok := PacketFlow(pkt)
+			if ok {
+				flow = flow.Conversation()
+			}
+			if flow != key {
+				t.Errorf("Unexpected packet in file for %v: %v", key, flow)
+				break
+			}
+		}
+	}
+
+	if total != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, total)
+	}
+	if len(files) < 2 {
+		t.Errorf("Unexpected number of files: %v", len(files))
+	}
+}
+
+// With only a few files open at once, files are closed and reopened for appending, and come out the
+// same as when they are all kept open.
+func TestSplitFlowsMaxOpenFiles(t *testing.T) {
+	files := make(map[FlowKey]*splitFile)
+	reopened := 0
+	err := SplitFlows(splitTestReader(t), SplitFlowsOptions{MaxOpenFiles: 2}, func(key FlowKey, appending bool) (io.WriteCloser, error) {
+		file, ok := files[key]
+		if ok != appending || (ok && !file.closed) {
+			t.Errorf("Unexpected create for %v: appending %v.", key, appending)
+		}
+		if !ok {
+			file = new(splitFile)
+			files[key] = file
+		} else {
+			reopened++
+		}
+
+		file.closed = false
+		open := 0
+		for _, f := range files {
+			if !f.closed {
+				open++
+			}
+		}
+		if open > 2 {
+			t.Errorf("Too many open files: %v", open)
+		}
+
+		return file, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reopened == 0 {
+		t.Errorf("No files were reopened.")
+	}
+
+	total := 0
+	for key, file := range files {
+		if !file.closed {
+			t.Errorf("File for %v was not closed.", key)
+		}
+		total += len(splitPackets(t, file))
+	}
+	if total != 2263 {
+		t.Errorf("Unexpected number of packets: expected %v, got %v.", 2263, total)
+	}
+}
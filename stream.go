@@ -0,0 +1,275 @@
+package gopcap
+
+import (
+	"sort"
+	"time"
+)
+
+// StreamHandler receives the reassembled data from one direction of a TCP connection.
+type StreamHandler interface {
+	// Data is called with the next bytes of the stream, in order. when is the capture time of the
+	// segment that carried them. data is only valid until Data returns.
+	Data(data []byte, when time.Time)
+
+	// Gap is called when the stream skips over missing bytes that were never captured, or that had
+	// to be given up on to stay within the memory limits.
+	Gap(missing int)
+
+	// Close is called when the stream ends, because of a FIN or a RST, or because it was flushed.
+	// No more calls are made after Close.
+	Close()
+}
+
+// StreamOptions configures a StreamAssembler. Segments that arrive ahead of a gap in the stream are
+// held until the gap is filled, so the limits here bound how much memory that can use. When a
+// stream goes over one of them, it gives up on its first gap.
+type StreamOptions struct {
+	MaxStreamBytes int // The most data that can be held for one direction of a connection. Defaults to 1MB.
+	MaxBytes       int // The most data that can be held for all connections. Defaults to 64MB.
+}
+
+// tcpPending is a segment's data that arrived ahead of a gap in the stream.
+type tcpPending struct {
+	seq     uint32
+	data    []byte
+	missing int // The number of bytes after data that weren't captured
+	when    time.Time
+}
+
+// tcpStream is one direction of a TCP connection.
+type tcpStream struct {
+	handler  StreamHandler
+	next     uint32       // The sequence number of the next byte to deliver
+	pending  []tcpPending // In sequence order
+	buffered int          // The number of bytes in pending
+	finSeen  bool
+	fin      uint32 // The sequence number of the FIN, if one has been seen
+	closed   bool
+}
+
+// tcpConnection is both directions of a TCP connection. The streams are indexed by direction: the
+// first is the direction of the connection's key.
+type tcpConnection struct {
+	streams [2]*tcpStream
+	last    time.Time // When the connection last saw a segment
+}
+
+// StreamAssembler reassembles the payloads of TCP segments into the byte streams that were sent.
+// Each direction of each connection is identified by its FlowKey, and gets its own StreamHandler
+// from the factory function, which is called when the first segment in that direction arrives.
+// Segments are put in order by sequence number, allowing for wraparound, and retransmitted or
+// overlapping data is only delivered once. Where segments overlap, the data that arrived first wins.
+//
+// A connection is only started by a SYN or a segment carrying data, so that the final ACKs of a
+// connection that has already closed are ignored. If the capture starts partway through a
+// connection, its streams start at the first segment seen. A StreamAssembler isn't safe for
+// concurrent use.
+type StreamAssembler struct {
+	factory     func(key FlowKey) StreamHandler
+	opts        StreamOptions
+	connections map[FlowKey]*tcpConnection // Keyed by conversation
+	buffered    int
+}
+
+// NewStreamAssembler creates a StreamAssembler that creates handlers with the factory function.
+func NewStreamAssembler(factory func(key FlowKey) StreamHandler, opts StreamOptions) *StreamAssembler {
+	if opts.MaxStreamBytes <= 0 {
+		opts.MaxStreamBytes = 1 << 20
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 64 << 20
+	}
+
+	return &StreamAssembler{factory: factory, opts: opts, connections: make(map[FlowKey]*tcpConnection)}
+}
+
+// Assemble adds a packet to its stream. It returns false if the packet isn't a TCP segment that can
+// be assembled, which includes later fragments of fragmented IP packets: use an IPv4Defragmenter or
+// IPv6Defragmenter to put those back together first.
+func (a *StreamAssembler) Assemble(pkt Packet) bool {
+	key, ok := PacketFlow(pkt)
+	if !ok || key.Protocol != IPP_TCP {
+		return false
+	}
+
+	a.AssembleSegment(key, pkt.Data.LinkData().InternetData().(*TCPSegment), pkt.Time)
+	return true
+}
+
+// AssembleSegment adds a TCP segment to the stream identified by key. when is the time it was
+// captured.
+func (a *StreamAssembler) AssembleSegment(key FlowKey, segment *TCPSegment, when time.Time) {
+	conversation := key.Conversation()
+	data := segment.TransportData()
+	missing := segment.missingPayload()
+
+	conn, ok := a.connections[conversation]
+	if !ok {
+		if !segment.SYN && len(data)+missing == 0 {
+			return
+		}
+		conn = new(tcpConnection)
+		a.connections[conversation] = conn
+	}
+	conn.last = when
+
+	direction := 0
+	if key != conversation {
+		direction = 1
+	}
+
+	// The SYN takes up the first sequence number, so the data starts after it.
+	seq := segment.SequenceNumber
+	if segment.SYN {
+		seq++
+	}
+
+	// A reset ends both directions straight away.
+	if segment.RST {
+		a.closeConnection(conversation, conn)
+		return
+	}
+
+	s := conn.streams[direction]
+	if s == nil {
+		s = &tcpStream{handler: a.factory(key), next: seq}
+		conn.streams[direction] = s
+	}
+	if s.closed {
+		return
+	}
+
+	if segment.FIN {
+		s.finSeen, s.fin = true, seq+uint32(len(data)+missing)
+	}
+	if len(data)+missing > 0 {
+		a.add(s, seq, data, missing, when)
+	}
+	a.deliver(s)
+
+	if (conn.streams[0] == nil || conn.streams[0].closed) && (conn.streams[1] == nil || conn.streams[1].closed) {
+		delete(a.connections, conversation)
+	}
+}
+
+// FlushOlderThan closes the connections that haven't seen a segment since cutoff, delivering any
+// data they are holding with gaps where data is missing. It returns the number of connections
+// closed.
+func (a *StreamAssembler) FlushOlderThan(cutoff time.Time) int {
+	closed := 0
+	for conversation, conn := range a.connections {
+		if conn.last.Before(cutoff) {
+			a.closeConnection(conversation, conn)
+			closed++
+		}
+	}
+	return closed
+}
+
+// FlushAll closes every connection, as at the end of a capture, delivering any data they are holding
+// with gaps where data is missing. It returns the number of connections closed.
+func (a *StreamAssembler) FlushAll() int {
+	closed := len(a.connections)
+	for conversation, conn := range a.connections {
+		a.closeConnection(conversation, conn)
+	}
+	return closed
+}
+
+// add delivers a segment's data if it's next in the stream, or holds onto it if it's ahead of a gap.
+// Data that has already been delivered is dropped. missing is the number of bytes after data that a
+// truncated capture left out: the stream skips over them with a gap.
+func (a *StreamAssembler) add(s *tcpStream, seq uint32, data []byte, missing int, when time.Time) {
+	offset := int32(seq - s.next)
+
+	if offset <= 0 {
+		behind := -int(offset)
+		if behind >= len(data)+missing {
+			return
+		}
+		if behind < len(data) {
+			data = data[behind:]
+			s.next += uint32(len(data))
+			s.handler.Data(data, when)
+		} else {
+			missing -= behind - len(data)
+		}
+		if missing > 0 {
+			s.next += uint32(missing)
+			s.handler.Gap(missing)
+		}
+		return
+	}
+
+	// Keep the pending segments in order of how far ahead of the stream they are.
+	i := sort.Search(len(s.pending), func(i int) bool { return int32(s.pending[i].seq-s.next) > offset })
+	s.pending = append(s.pending, tcpPending{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = tcpPending{seq: seq, data: append([]byte{}, data...), missing: missing, when: when}
+	s.buffered += len(data)
+	a.buffered += len(data)
+
+	for s.buffered > a.opts.MaxStreamBytes || a.buffered > a.opts.MaxBytes {
+		if !a.skip(s) {
+			break
+		}
+	}
+}
+
+// deliver delivers whatever pending data has become next in the stream, and closes the stream if
+// it has reached its FIN.
+func (a *StreamAssembler) deliver(s *tcpStream) {
+	for len(s.pending) > 0 && int32(s.pending[0].seq-s.next) <= 0 {
+		p := s.pending[0]
+		s.pending = s.pending[1:]
+		s.buffered -= len(p.data)
+		a.buffered -= len(p.data)
+		a.add(s, p.seq, p.data, p.missing, p.when)
+	}
+
+	if s.finSeen && s.next == s.fin && !s.closed {
+		s.next++
+		a.finish(s)
+	}
+}
+
+// skip gives up on the first gap in the stream, and delivers the data after it. It returns false if
+// there is no gap to skip.
+func (a *StreamAssembler) skip(s *tcpStream) bool {
+	if len(s.pending) == 0 {
+		return false
+	}
+
+	s.handler.Gap(int(s.pending[0].seq - s.next))
+	s.next = s.pending[0].seq
+	a.deliver(s)
+	return true
+}
+
+// closeStream closes one direction of a connection, delivering any data it is holding first.
+func (a *StreamAssembler) closeStream(s *tcpStream) {
+	for !s.closed && a.skip(s) {
+	}
+
+	if !s.closed {
+		a.finish(s)
+	}
+}
+
+// finish marks a stream as closed and tells its handler. Any data it is still holding comes after
+// the end of the stream, so it is thrown away.
+func (a *StreamAssembler) finish(s *tcpStream) {
+	a.buffered -= s.buffered
+	s.pending, s.buffered, s.closed = nil, 0, true
+	s.handler.Close()
+}
+
+// closeConnection closes both directions of a connection and forgets it.
+func (a *StreamAssembler) closeConnection(conversation FlowKey, conn *tcpConnection) {
+	for _, s := range conn.streams {
+		if s != nil {
+			a.closeStream(s)
+		}
+	}
+	delete(a.connections, conversation)
+}
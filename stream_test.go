@@ -0,0 +1,251 @@
+package gopcap
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStream records what a StreamHandler is given.
+type testStream struct {
+	t      *testing.T
+	data   []byte
+	gaps   []int
+	closed bool
+}
+
+func (s *testStream) Data(data []byte, when time.Time) {
+	if s.closed {
+		s.t.Errorf("Data after close: %q", data)
+	}
+	s.data = append(s.data, data...)
+}
+
+func (s *testStream) Gap(missing int) {
+	if s.closed {
+		s.t.Errorf("Gap after close: %v", missing)
+	}
+	s.gaps = append(s.gaps, missing)
+}
+
+func (s *testStream) Close() {
+	if s.closed {
+		s.t.Errorf("Stream closed twice.")
+	}
+	s.closed = true
+}
+
+var streamTestKey = FlowKey{
+	Protocol:           IPP_TCP,
+	SourceAddress:      netip.MustParseAddr("10.0.0.1"),
+	DestinationAddress: netip.MustParseAddr("10.0.0.2"),
+	SourcePort:         1024,
+	DestinationPort:    80,
+}
+
+// newTestAssembler creates a StreamAssembler whose handlers are kept in streams. If a connection is
+// reused, only the latest handler for each key is kept.
+func newTestAssembler(t *testing.T, opts StreamOptions) (*StreamAssembler, map[FlowKey]*testStream) {
+	streams := make(map[FlowKey]*testStream)
+	a := NewStreamAssembler(func(key FlowKey) StreamHandler {
+		if streams[key] != nil && !streams[key].closed {
+			t.Errorf("Stream created twice: %v", key)
+		}
+		streams[key] = &testStream{t: t}
+		return streams[key]
+	}, opts)
+	return a, streams
+}
+
+// streamSegment creates a segment with the given flags, from "SAFR".
+func streamSegment(seq uint32, flags string, data string) *TCPSegment {
+	return &TCPSegment{
+		SequenceNumber: seq,
+		SYN:            strings.Contains(flags, "S"),
+		ACK:            strings.Contains(flags, "A"),
+		FIN:            strings.Contains(flags, "F"),
+		RST:            strings.Contains(flags, "R"),
+		data:           []byte(data),
+	}
+}
+
+func TestStreamAssembler(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+	reverse := streamTestKey.Reverse()
+
+	a.AssembleSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	a.AssembleSegment(reverse, streamSegment(4999, "SA", ""), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "hello "), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1006, "A", "world"), defragTestTime)
+	a.AssembleSegment(reverse, streamSegment(5000, "A", "ok"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1011, "AF", ""), defragTestTime)
+
+	if string(streams[streamTestKey].data) != "hello world" || !streams[streamTestKey].closed {
+		t.Errorf("Unexpected stream: %+v", streams[streamTestKey])
+	}
+	if string(streams[reverse].data) != "ok" || streams[reverse].closed {
+		t.Errorf("Unexpected stream: %+v", streams[reverse])
+	}
+
+	// Once both directions are closed, the connection is forgotten, and the final ACK is ignored.
+	a.AssembleSegment(reverse, streamSegment(5002, "AF", ""), defragTestTime)
+	if !streams[reverse].closed || len(a.connections) != 0 {
+		t.Errorf("Unexpected stream: %+v", streams[reverse])
+	}
+	a.AssembleSegment(streamTestKey, streamSegment(1012, "A", ""), defragTestTime)
+	if len(a.connections) != 0 {
+		t.Errorf("Unexpected connections: %v", a.connections)
+	}
+}
+
+// Segments can arrive out of order, more than once, or overlapping each other.
+func TestStreamAssemblerOrdering(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+
+	a.AssembleSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1011, "AF", "!"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1006, "A", "world"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "hel"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "hel"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1003, "A", "lo wo"), defragTestTime)
+
+	s := streams[streamTestKey]
+	if string(s.data) != "hello world!" || len(s.gaps) != 0 || !s.closed {
+		t.Errorf("Unexpected stream: %q, gaps %v, closed %v", s.data, s.gaps, s.closed)
+	}
+	if a.buffered != 0 {
+		t.Errorf("Unexpected buffered data: %v", a.buffered)
+	}
+}
+
+func TestStreamAssemblerWraparound(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+
+	a.AssembleSegment(streamTestKey, streamSegment(0xFFFFFFFD, "S", ""), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(2, "A", "ef"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(0xFFFFFFFE, "A", "abcd"), defragTestTime)
+
+	if string(streams[streamTestKey].data) != "abcdef" {
+		t.Errorf("Unexpected data: %q", streams[streamTestKey].data)
+	}
+}
+
+// A stream that holds too much gives up on its gap.
+func TestStreamAssemblerLimits(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{MaxStreamBytes: 4})
+
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "ab"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1010, "A", "cdef"), defragTestTime)
+	if string(streams[streamTestKey].data) != "ab" {
+		t.Errorf("Unexpected data: %q", streams[streamTestKey].data)
+	}
+
+	a.AssembleSegment(streamTestKey, streamSegment(1014, "A", "g"), defragTestTime)
+	s := streams[streamTestKey]
+	if string(s.data) != "abcdefg" || len(s.gaps) != 1 || s.gaps[0] != 8 {
+		t.Errorf("Unexpected stream: %q, gaps %v", s.data, s.gaps)
+	}
+
+	// The missing data is too late now.
+	a.AssembleSegment(streamTestKey, streamSegment(1002, "A", "xxxxxxxx"), defragTestTime)
+	if string(s.data) != "abcdefg" || a.buffered != 0 {
+		t.Errorf("Unexpected data: %q", s.data)
+	}
+}
+
+// Segments cut short by the snap length skip over the bytes that weren't captured.
+func TestStreamAssemblerTruncated(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+	truncated := func(seq uint32, flags string, data string, missing uint32) *TCPSegment {
+		segment := streamSegment(seq, flags, data)
+		segment.Truncated, segment.MissingBytes = true, missing
+		return segment
+	}
+
+	a.AssembleSegment(streamTestKey, streamSegment(999, "S", ""), defragTestTime)
+	a.AssembleSegment(streamTestKey, truncated(1010, "A", "cd", 8), defragTestTime)
+	a.AssembleSegment(streamTestKey, truncated(1000, "A", "ab", 8), defragTestTime)
+	for i := uint32(2); i < 50; i++ {
+		a.AssembleSegment(streamTestKey, truncated(1000+i*10, "A", "xy", 8), defragTestTime)
+	}
+	a.AssembleSegment(streamTestKey, truncated(1500, "AF", "", 10), defragTestTime)
+
+	s := streams[streamTestKey]
+	if len(s.data) != 100 || !strings.HasPrefix(string(s.data), "abcdxy") || len(s.gaps) != 51 || !s.closed {
+		t.Errorf("Unexpected stream: %q, gaps %v, closed %v", s.data, s.gaps, s.closed)
+	}
+	if a.buffered != 0 {
+		t.Errorf("Unexpected buffered data: %v", a.buffered)
+	}
+}
+
+// A reset closes both directions, delivering whatever was held.
+func TestStreamAssemblerReset(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+	reverse := streamTestKey.Reverse()
+
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "GET"), defragTestTime)
+	a.AssembleSegment(streamTestKey, streamSegment(1005, "A", "/"), defragTestTime)
+	a.AssembleSegment(reverse, streamSegment(5000, "A", "HTTP"), defragTestTime)
+	a.AssembleSegment(reverse, streamSegment(5004, "R", ""), defragTestTime)
+
+	s := streams[streamTestKey]
+	if string(s.data) != "GET/" || len(s.gaps) != 1 || s.gaps[0] != 2 || !s.closed || !streams[reverse].closed {
+		t.Errorf("Unexpected stream: %q, gaps %v, closed %v", s.data, s.gaps, s.closed)
+	}
+	if len(a.connections) != 0 || a.buffered != 0 {
+		t.Errorf("Unexpected connections: %v", a.connections)
+	}
+}
+
+func TestStreamAssemblerFlush(t *testing.T) {
+	a, streams := newTestAssembler(t, StreamOptions{})
+	other := streamTestKey
+	other.SourcePort = 1025
+
+	a.AssembleSegment(streamTestKey, streamSegment(1000, "A", "old"), defragTestTime)
+	a.AssembleSegment(other, streamSegment(1000, "A", "new"), defragTestTime.Add(time.Minute))
+	a.AssembleSegment(other, streamSegment(1005, "A", "er"), defragTestTime.Add(time.Minute))
+
+	if a.FlushOlderThan(defragTestTime.Add(time.Second)) != 1 || !streams[streamTestKey].closed || streams[other].closed {
+		t.Errorf("Unexpected streams: %+v, %+v", streams[streamTestKey], streams[other])
+	}
+	if a.FlushAll() != 1 || string(streams[other].data) != "newer" || !streams[other].closed {
+		t.Errorf("Unexpected stream: %+v", streams[other])
+	}
+}
+
+// Every stream in the test file should come out whole, including the IRC traffic it is named for.
+func TestStreamAssemblerFile(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	a, streams := newTestAssembler(t, StreamOptions{})
+	r, _ := NewReader(bytes.NewReader(original))
+	for {
+		pkt, err := r.Next()
+		if err != nil {
+			break
+		}
+		a.Assemble(pkt)
+	}
+	a.FlushAll()
+
+	irc := false
+	for key, s := range streams {
+		if !s.closed {
+			t.Errorf("Stream wasn't closed: %v", key)
+		}
+		if key.SourcePort == 6667 && bytes.Contains(s.data, []byte("PRIVMSG")) {
+			irc = true
+		}
+	}
+	if len(streams) == 0 || !irc {
+		t.Errorf("Unexpected streams: %v streams, IRC %v.", len(streams), irc)
+	}
+}
@@ -1,5 +1,9 @@
 package gopcap
 
+import (
+	"reflect"
+)
+
 //-----------------------------------------------------------------------------
 // Unknown Transport
 //-----------------------------------------------------------------------------
@@ -20,6 +24,10 @@ func (u *UnknownTransport) FromBytes(data []byte) error {
 	return nil
 }
 
+func (u *UnknownTransport) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	return append(b, u.data...), nil
+}
+
 //-----------------------------------------------------------------------------
 // TCPSegment
 //-----------------------------------------------------------------------------
@@ -44,7 +52,13 @@ type TCPSegment struct {
 	WindowSize      uint16
 	Checksum        uint16
 	UrgentOffset    uint16
-	OptionData      []byte // This is temporary. We should handle TCP options properly.
+	OptionData      []byte      // The raw options, including any padding
+	Options         []TCPOption // The options, decoded from OptionData. If changed, OptionData is re-encoded from them when serializing; an empty, non-nil slice removes them all.
+	Truncated       bool        // Set if the capture didn't include the whole segment
+	MissingBytes    uint32      // The number of bytes of the segment that weren't captured
+	portsMissing    bool        // Set if the capture stopped before the end of the ports
+	headerMissing   bool        // Set if the capture stopped before the end of the fixed header
+	sum             checksumState
 	data            []byte
 }
 
@@ -53,11 +67,15 @@ func (t *TCPSegment) TransportData() []byte {
 }
 
 func (t *TCPSegment) FromBytes(data []byte) error {
-	// Begin by confirming that we have enough data for a complete TCP header.
+	// Begin by confirming that we have enough data for a complete TCP header. If the capture stopped
+	// partway through it, decode whichever fields it did include.
 	if len(data) < 20 {
+		t.fromPartialHeader(data)
 		return InsufficientLength
 	}
 
+	t.sum.raw = data
+
 	// The first four fields are really easy.
 	t.SourcePort = getUint16(data[0:2], false)
 	t.DestinationPort = getUint16(data[2:4], false)
@@ -66,14 +84,95 @@ func (t *TCPSegment) FromBytes(data []byte) error {
 
 	// The header size is the top four bits of the next byte.
 	t.HeaderSize = uint8(data[12]) >> 4
+	t.setFlags(data[12], data[13])
+
+	// Now we're back to sane things.
+	t.WindowSize = getUint16(data[14:16], false)
+	t.Checksum = getUint16(data[16:18], false)
+	t.UrgentOffset = getUint16(data[18:20], false)
 
-	// Now we have all the flag fields. First, the NS flag.
-	if (uint8(data[12]) & 0x01) != 0 {
+	// If the header size is larger than 5 (it's measured in 32-bit words for reasons that escape me),
+	// we have some number of extra bytes that form the TCP options.
+	if t.HeaderSize < 5 {
+		return IncorrectPacket
+	}
+	extraBytes := int(t.HeaderSize-5) * 4
+	data = data[20:]
+
+	// If the capture stopped partway through the options, keep the ones we have.
+	if len(data) < extraBytes {
+		t.OptionData = data
+		t.Options = parseTCPOptions(t.OptionData)
+		t.Truncated = true
+		t.MissingBytes = uint32(extraBytes - len(data))
+		t.data = data[len(data):]
+		return nil
+	}
+
+	t.OptionData = data[:extraBytes]
+	t.Options = parseTCPOptions(t.OptionData)
+
+	// All that remains is the contained data.
+	t.data = data[extraBytes:]
+
+	return nil
+}
+
+// fromPartialHeader decodes the fields of a TCP header that the capture cut short. Only fields that
+// were captured whole are decoded.
+func (t *TCPSegment) fromPartialHeader(data []byte) {
+	t.portsMissing = len(data) < 4
+	t.headerMissing = true
+	if len(data) >= 4 {
+		t.SourcePort = getUint16(data[0:2], false)
+		t.DestinationPort = getUint16(data[2:4], false)
+	}
+	if len(data) >= 8 {
+		t.SequenceNumber = getUint32(data[4:8], false)
+	}
+	if len(data) >= 12 {
+		t.AckNumber = getUint32(data[8:12], false)
+	}
+	if len(data) >= 14 {
+		t.HeaderSize = uint8(data[12]) >> 4
+		t.setFlags(data[12], data[13])
+	}
+	if len(data) >= 16 {
+		t.WindowSize = getUint16(data[14:16], false)
+	}
+	if len(data) >= 18 {
+		t.Checksum = getUint16(data[16:18], false)
+	}
+}
+
+// missingPayload returns the number of bytes of the payload that weren't captured: MissingBytes, less
+// any options that weren't captured either. It's 0 if the fixed header was cut short, as then the
+// size of the payload isn't known.
+func (t *TCPSegment) missingPayload() int {
+	if !t.Truncated || t.headerMissing {
+		return 0
+	}
+
+	missing := int(t.MissingBytes)
+	if t.HeaderSize > 5 {
+		if options := int(t.HeaderSize-5)*4 - len(t.OptionData); options > 0 {
+			missing -= options
+		}
+	}
+	if missing < 0 {
+		return 0
+	}
+	return missing
+}
+
+// setFlags decodes the flags from the two bytes of the header that hold them.
+func (t *TCPSegment) setFlags(offset uint8, flags uint8) {
+	// First, the NS flag, which shares its byte with the header size.
+	if (offset & 0x01) != 0 {
 		t.NS = true
 	}
 
 	// The next eight flags are all in the next byte.
-	flags := uint8(data[13])
 	if (flags & 0x80) != 0 {
 		t.CWR = true
 	}
@@ -98,29 +197,310 @@ func (t *TCPSegment) FromBytes(data []byte) error {
 	if (flags & 0x01) != 0 {
 		t.FIN = true
 	}
+}
 
-	// Now we're back to sane things.
-	t.WindowSize = getUint16(data[14:16], false)
-	t.Checksum = getUint16(data[16:18], false)
-	t.UrgentOffset = getUint16(data[18:20], false)
+// AppendBytes appends the segment to b. The checksum can only be recomputed when serializing the IP
+// packet containing the segment. If Options is set and no longer matches OptionData, OptionData is
+// re-encoded from Options first, padded to a multiple of four bytes, and updated in the segment along
+// with HeaderSize. Options that take up more than 40 bytes return InvalidField.
+func (t *TCPSegment) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	if t.Options != nil && !tcpOptionsEqual(t.Options, parseTCPOptions(t.OptionData)) {
+		encoded := make([]byte, 0, 40)
+		for _, option := range t.Options {
+			var err error
+			encoded, err = appendTCPOption(encoded, option)
+			if err != nil {
+				return b, err
+			}
+		}
+		encoded = padOptions(encoded)
+		if len(encoded) > 40 {
+			return b, InvalidField
+		}
+		t.OptionData = encoded
+		t.HeaderSize = uint8(5 + len(encoded)/4)
+	}
 
-	// If the header size is larger than 5 (it's measured in 32-bit words for reasons that escape me),
-	// we have some number of extra bytes that form the TCP options.
-	extraBytes := (t.HeaderSize - 5) * 4
-	data = data[20:]
+	options := t.OptionData
+	if opts.FixLengths {
+		options = padOptions(options)
+		if len(options) > 40 {
+			return b, InvalidField
+		}
+		t.HeaderSize = uint8(5 + len(options)/4)
+	}
 
-	if len(data) < int(extraBytes) {
-		return InsufficientLength
+	header := make([]byte, 20)
+	putUint16(header[0:2], t.SourcePort, false)
+	putUint16(header[2:4], t.DestinationPort, false)
+	putUint32(header[4:8], t.SequenceNumber, false)
+	putUint32(header[8:12], t.AckNumber, false)
+
+	header[12] = t.HeaderSize << 4
+	if t.NS {
+		header[12] |= 0x01
 	}
 
-	t.OptionData = data[:extraBytes]
+	flags := []bool{t.CWR, t.ECE, t.URG, t.ACK, t.PSH, t.RST, t.SYN, t.FIN}
+	for i, set := range flags {
+		if set {
+			header[13] |= 0x80 >> uint(i)
+		}
+	}
 
-	// All that remains is the contained data.
-	t.data = data[extraBytes:]
+	putUint16(header[14:16], t.WindowSize, false)
+	putUint16(header[16:18], t.Checksum, false)
+	putUint16(header[18:20], t.UrgentOffset, false)
+
+	b = append(b, header...)
+	b = append(b, options...)
+	return append(b, t.data...), nil
+}
+
+// VerifyChecksum checks the checksum against the bytes the segment was decoded from, and the
+// pseudo-header of the IP packet that contained it.
+func (t *TCPSegment) VerifyChecksum() ChecksumStatus {
+	return t.sum.verify(t.Checksum, false)
+}
 
+// Option returns the first option of the given kind, or nil if the segment doesn't have one.
+func (t *TCPSegment) Option(kind TCPOptionKind) TCPOption {
+	for _, option := range t.Options {
+		if option.OptionKind() == kind {
+			return option
+		}
+	}
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// TCP options
+//-----------------------------------------------------------------------------
+
+// TCPOptionKind identifies the kind of a TCP option.
+type TCPOptionKind uint8
+
+const (
+	TCPOPT_END            TCPOptionKind = 0
+	TCPOPT_NOP            TCPOptionKind = 1
+	TCPOPT_MSS            TCPOptionKind = 2
+	TCPOPT_WINDOW_SCALE   TCPOptionKind = 3
+	TCPOPT_SACK_PERMITTED TCPOptionKind = 4
+	TCPOPT_SACK           TCPOptionKind = 5
+	TCPOPT_TIMESTAMPS     TCPOptionKind = 8
+	TCPOPT_MPTCP          TCPOptionKind = 30
+	TCPOPT_FAST_OPEN      TCPOptionKind = 34
+)
+
+// TCPOption is a single decoded TCP option. It is one of the TCPOption structs below: an option that
+// gopcap doesn't understand, or that has the wrong length for its kind, is a TCPOptionUnknown. The
+// End of Option List and No-Operation options are only padding, so they aren't included.
+type TCPOption interface {
+	OptionKind() TCPOptionKind
+}
+
+// TCPOptionMSS is the Maximum Segment Size option, sent with SYN segments.
+type TCPOptionMSS struct {
+	MSS uint16
+}
+
+// TCPOptionWindowScale is the Window Scale option (RFC 7323), sent with SYN segments. Once both ends
+// have sent it, each end's WindowSize is shifted left by the Shift it sent.
+type TCPOptionWindowScale struct {
+	Shift uint8
+}
+
+// TCPOptionSACKPermitted is the SACK-Permitted option (RFC 2018), sent with SYN segments.
+type TCPOptionSACKPermitted struct{}
+
+// TCPSACKBlock is a block of data that has been received, from LeftEdge up to but not including
+// RightEdge.
+type TCPSACKBlock struct {
+	LeftEdge  uint32
+	RightEdge uint32
+}
+
+// TCPOptionSACK is the SACK option (RFC 2018), listing blocks of data that have been received out of
+// order.
+type TCPOptionSACK struct {
+	Blocks []TCPSACKBlock
+}
+
+// TCPOptionTimestamps is the Timestamps option (RFC 7323).
+type TCPOptionTimestamps struct {
+	TSval uint32 // The sender's timestamp clock
+	TSecr uint32 // The most recent TSval received from the other end
+}
+
+// TCPOptionFastOpen is the TCP Fast Open option (RFC 7413). The cookie is empty in a SYN that is
+// asking for one.
+type TCPOptionFastOpen struct {
+	Cookie []byte
+}
+
+// TCPOptionMPTCP is a Multipath TCP option (RFC 8684). Its layout depends on the subtype, so the data
+// is left as it is, starting with the byte that holds the subtype.
+type TCPOptionMPTCP struct {
+	Subtype uint8
+	Data    []byte
+}
+
+// TCPOptionUnknown is an option that gopcap doesn't decode.
+type TCPOptionUnknown struct {
+	Kind TCPOptionKind
+	Data []byte
+}
+
+func (o TCPOptionMSS) OptionKind() TCPOptionKind {
+	return TCPOPT_MSS
+}
+
+func (o TCPOptionWindowScale) OptionKind() TCPOptionKind {
+	return TCPOPT_WINDOW_SCALE
+}
+
+func (o TCPOptionSACKPermitted) OptionKind() TCPOptionKind {
+	return TCPOPT_SACK_PERMITTED
+}
+
+func (o TCPOptionSACK) OptionKind() TCPOptionKind {
+	return TCPOPT_SACK
+}
+
+func (o TCPOptionTimestamps) OptionKind() TCPOptionKind {
+	return TCPOPT_TIMESTAMPS
+}
+
+func (o TCPOptionFastOpen) OptionKind() TCPOptionKind {
+	return TCPOPT_FAST_OPEN
+}
+
+func (o TCPOptionMPTCP) OptionKind() TCPOptionKind {
+	return TCPOPT_MPTCP
+}
+
+func (o TCPOptionUnknown) OptionKind() TCPOptionKind {
+	return o.Kind
+}
+
+// parseTCPOptions decodes the options in a TCP header. Decoding stops at the End of Option List
+// option, or at an option that runs past the end of the data, which can happen if the capture was
+// truncated.
+func parseTCPOptions(data []byte) []TCPOption {
+	options := []TCPOption{}
+
+	for len(data) > 0 {
+		kind := TCPOptionKind(data[0])
+		if kind == TCPOPT_END {
+			break
+		} else if kind == TCPOPT_NOP {
+			data = data[1:]
+			continue
+		}
+
+		// Every other option has a length, which includes the kind and the length itself.
+		if len(data) < 2 || data[1] < 2 || int(data[1]) > len(data) {
+			break
+		}
+		value := data[2:data[1]]
+		data = data[data[1]:]
+
+		options = append(options, parseTCPOption(kind, value))
+	}
+
+	return options
+}
+
+// parseTCPOption decodes a single option, given its kind and the data that follows its length.
+func parseTCPOption(kind TCPOptionKind, value []byte) TCPOption {
+	switch {
+	case kind == TCPOPT_MSS && len(value) == 2:
+		return TCPOptionMSS{MSS: getUint16(value, false)}
+	case kind == TCPOPT_WINDOW_SCALE && len(value) == 1:
+		return TCPOptionWindowScale{Shift: value[0]}
+	case kind == TCPOPT_SACK_PERMITTED && len(value) == 0:
+		return TCPOptionSACKPermitted{}
+	case kind == TCPOPT_SACK && len(value)%8 == 0:
+		blocks := make([]TCPSACKBlock, len(value)/8)
+		for i := range blocks {
+			blocks[i].LeftEdge = getUint32(value[i*8:i*8+4], false)
+			blocks[i].RightEdge = getUint32(value[i*8+4:i*8+8], false)
+		}
+		return TCPOptionSACK{Blocks: blocks}
+	case kind == TCPOPT_TIMESTAMPS && len(value) == 8:
+		return TCPOptionTimestamps{TSval: getUint32(value[0:4], false), TSecr: getUint32(value[4:8], false)}
+	case kind == TCPOPT_FAST_OPEN:
+		return TCPOptionFastOpen{Cookie: value}
+	case kind == TCPOPT_MPTCP && len(value) > 0:
+		return TCPOptionMPTCP{Subtype: value[0] >> 4, Data: value}
+	}
+	return TCPOptionUnknown{Kind: kind, Data: value}
+}
+
+// tcpOptionsEqual reports whether two option lists hold the same options.
+func tcpOptionsEqual(a, b []TCPOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTCPOption appends the wire form of a single option to b. END and NOP are a single byte, so
+// they can't have any data. An option whose data is too long for its length byte, or that isn't one
+// of gopcap's TCPOption structs, returns InvalidField.
+func appendTCPOption(b []byte, option TCPOption) ([]byte, error) {
+	var value []byte
+
+	switch o := option.(type) {
+	case TCPOptionMSS:
+		value = make([]byte, 2)
+		putUint16(value, o.MSS, false)
+	case TCPOptionWindowScale:
+		value = []byte{o.Shift}
+	case TCPOptionSACKPermitted:
+	case TCPOptionSACK:
+		value = make([]byte, 8*len(o.Blocks))
+		for i, block := range o.Blocks {
+			putUint32(value[i*8:i*8+4], block.LeftEdge, false)
+			putUint32(value[i*8+4:i*8+8], block.RightEdge, false)
+		}
+	case TCPOptionTimestamps:
+		value = make([]byte, 8)
+		putUint32(value[0:4], o.TSval, false)
+		putUint32(value[4:8], o.TSecr, false)
+	case TCPOptionFastOpen:
+		value = o.Cookie
+	case TCPOptionMPTCP:
+		// The subtype shares the first byte of the data.
+		value = append([]byte{0}, o.Data...)
+		if len(o.Data) > 0 {
+			value = value[1:]
+		}
+		value[0] = (o.Subtype << 4) | (value[0] & 0x0F)
+	case TCPOptionUnknown:
+		if o.Kind == TCPOPT_END || o.Kind == TCPOPT_NOP {
+			if len(o.Data) != 0 {
+				return b, InvalidField
+			}
+			return append(b, uint8(o.Kind)), nil
+		}
+		value = o.Data
+	default:
+		return b, InvalidField
+	}
+
+	if len(value) > 253 {
+		return b, InvalidField
+	}
+	b = append(b, uint8(option.OptionKind()), uint8(2+len(value)))
+	return append(b, value...), nil
+}
+
 //-----------------------------------------------------------------------------
 // UDPDatagram
 //-----------------------------------------------------------------------------
@@ -132,6 +512,10 @@ type UDPDatagram struct {
 	DestinationPort uint16
 	Length          uint16
 	Checksum        uint16
+	Truncated       bool   // Set if the capture didn't include the whole datagram
+	MissingBytes    uint32 // The number of bytes of the datagram that weren't captured
+	portsMissing    bool   // Set if the capture stopped before the end of the ports
+	sum             checksumState
 	data            []byte
 }
 
@@ -140,8 +524,17 @@ func (u *UDPDatagram) TransportData() []byte {
 }
 
 func (u *UDPDatagram) FromBytes(data []byte) error {
-	// Begin by confirming that we have enough data to actually represent a UDP datagram.
+	// Begin by confirming that we have enough data to actually represent a UDP datagram. If the
+	// capture stopped partway through the header, decode whichever fields it did include.
 	if len(data) < 8 {
+		u.portsMissing = len(data) < 4
+		if len(data) >= 4 {
+			u.SourcePort = getUint16(data[0:2], false)
+			u.DestinationPort = getUint16(data[2:4], false)
+		}
+		if len(data) >= 6 {
+			u.Length = getUint16(data[4:6], false)
+		}
 		return InsufficientLength
 	}
 
@@ -151,8 +544,60 @@ func (u *UDPDatagram) FromBytes(data []byte) error {
 	u.Length = getUint16(data[4:6], false)
 	u.Checksum = getUint16(data[6:8], false)
 
-	// All that remains is data.
-	u.data = data[8:]
+	// All that remains is data. The length covers both the header and the data, so if it's longer
+	// than what we have the capture didn't include the whole datagram.
+	if int(u.Length) > len(data) {
+		u.Truncated = true
+		u.MissingBytes = uint32(int(u.Length) - len(data))
+		u.data = data[8:]
+	} else if u.Length >= 8 {
+		u.data = data[8:u.Length]
+	} else {
+		u.data = data[8:]
+	}
+	u.sum.raw = data[:8+len(u.data)]
 
 	return nil
 }
+
+// VerifyChecksum checks the checksum against the bytes the datagram was decoded from, and the
+// pseudo-header of the IP packet that contained it. Over IPv4, a zero checksum means the sender
+// didn't compute one. Over IPv6 the checksum is mandatory (RFC 8200), so a zero one is invalid.
+func (u *UDPDatagram) VerifyChecksum() ChecksumStatus {
+	return u.sum.verify(u.Checksum, u.sum.version == 4)
+}
+
+// AppendBytes appends the datagram to b. The checksum can only be recomputed when serializing the IP
+// packet containing the datagram.
+func (u *UDPDatagram) AppendBytes(b []byte, opts SerializeOptions) ([]byte, error) {
+	if opts.FixLengths {
+		if 8+len(u.data) > 0xFFFF {
+			return b, InvalidField
+		}
+		u.Length = uint16(8 + len(u.data))
+	}
+
+	header := make([]byte, 8)
+	putUint16(header[0:2], u.SourcePort, false)
+	putUint16(header[2:4], u.DestinationPort, false)
+	putUint16(header[4:6], u.Length, false)
+	putUint16(header[6:8], u.Checksum, false)
+
+	b = append(b, header...)
+	return append(b, u.data...), nil
+}
+
+// markTruncated records that a transport-layer packet is missing the given number of bytes,
+// because the capture didn't include the whole of the internet-layer packet containing it.
+func markTruncated(layer TransportLayer, missing uint32) {
+	switch t := layer.(type) {
+	case *TCPSegment:
+		t.Truncated = true
+		t.MissingBytes = missing
+		t.sum.truncated = true
+	case *UDPDatagram:
+		t.Truncated = true
+		t.MissingBytes = missing
+		t.sum.truncated = true
+	}
+}
@@ -108,3 +108,224 @@ func TestUDPGood(t *testing.T) {
 		t.Errorf("Unexpected length of contained data: expected %v, got %v", 42, len(dgram.TransportData()))
 	}
 }
+
+func TestTCPTruncatedOptions(t *testing.T) {
+	// The TCP header from the test frame, cut off after four bytes of its twelve bytes of options.
+	data := ngTestFrame[34 : 34+20+4]
+
+	pkt := new(TCPSegment)
+	err := pkt.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if pkt.DestinationPort != 6667 {
+		t.Errorf("Unexpected destination port: expected %v, got %v", 6667, pkt.DestinationPort)
+	}
+	if !pkt.Truncated || pkt.MissingBytes != 8 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 8, pkt.MissingBytes, pkt.Truncated)
+	}
+	if len(pkt.OptionData) != 4 {
+		t.Errorf("Unexpected option length: expected %v, got %v", 4, len(pkt.OptionData))
+	}
+	if len(pkt.TransportData()) != 0 {
+		t.Errorf("Unexpected transport data: %v", pkt.TransportData())
+	}
+
+	// When the IP layer says more is missing, the rest is payload.
+	if pkt.missingPayload() != 0 {
+		t.Errorf("Unexpected missing payload: %v", pkt.missingPayload())
+	}
+	markTruncated(pkt, 38)
+	if pkt.missingPayload() != 30 {
+		t.Errorf("Unexpected missing payload: expected %v, got %v", 30, pkt.missingPayload())
+	}
+}
+
+func TestTCPTruncatedHeader(t *testing.T) {
+	// The TCP header from the test frame, cut off partway through the window size.
+	pkt := new(TCPSegment)
+	err := pkt.FromBytes(ngTestFrame[34 : 34+15])
+
+	if err != InsufficientLength {
+		t.Errorf("Unexpected error: expected %v, got %v", InsufficientLength, err)
+	}
+	if pkt.SourcePort != 2848 || pkt.DestinationPort != 6667 || pkt.AckNumber != 0x54F11072 {
+		t.Errorf("Unexpected segment: %+v", pkt)
+	}
+	if pkt.HeaderSize != 8 || !pkt.ACK || !pkt.PSH || pkt.WindowSize != 0 {
+		t.Errorf("Unexpected segment: %+v", pkt)
+	}
+}
+
+func TestUDPTruncated(t *testing.T) {
+	// A datagram claiming 50 bytes, of which only 12 were captured.
+	data := []byte{0x08, 0x50, 0x00, 0x35, 0x00, 0x32, 0x83, 0x97, 0x31, 0x1f, 0x01, 0x00}
+
+	dgram := new(UDPDatagram)
+	err := dgram.FromBytes(data)
+
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !dgram.Truncated || dgram.MissingBytes != 38 {
+		t.Errorf("Unexpected truncation: expected %v missing bytes, got %v (%v)", 38, dgram.MissingBytes, dgram.Truncated)
+	}
+	if len(dgram.TransportData()) != 4 {
+		t.Errorf("Unexpected length of contained data: expected %v, got %v", 4, len(dgram.TransportData()))
+	}
+
+	// With only part of the header, the ports are still decoded.
+	dgram = new(UDPDatagram)
+	err = dgram.FromBytes(data[:5])
+	if err != InsufficientLength || dgram.SourcePort != 2128 || dgram.DestinationPort != 53 || dgram.Length != 0 {
+		t.Errorf("Unexpected datagram: %+v, %v", dgram, err)
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+	// The test frame has two NOPs and then a timestamp.
+	pkt := new(TCPSegment)
+	pkt.FromBytes(ngTestFrame[34:])
+
+	if len(pkt.Options) != 1 {
+		t.Fatalf("Unexpected options: %v", pkt.Options)
+	}
+	ts, ok := pkt.Option(TCPOPT_TIMESTAMPS).(TCPOptionTimestamps)
+	if !ok || ts.TSval != 0x00D8EA48 || ts.TSecr != 0x82E4DAB0 {
+		t.Errorf("Unexpected timestamps: %+v", pkt.Option(TCPOPT_TIMESTAMPS))
+	}
+	if pkt.Option(TCPOPT_MSS) != nil {
+		t.Errorf("Unexpected MSS: %+v", pkt.Option(TCPOPT_MSS))
+	}
+}
+
+func TestTCPOptionsSYN(t *testing.T) {
+	// A typical SYN: MSS, SACK permitted, timestamps, NOP, window scale, then a Fast Open cookie
+	// request, an MPTCP MP_CAPABLE option and an experimental option.
+	options := []byte{
+		0x02, 0x04, 0x05, 0xB4, 0x04, 0x02, 0x08, 0x0A, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07,
+		0x22, 0x02, 0x1E, 0x04, 0x00, 0x81, 0xFD, 0x03, 0xAB, 0x00,
+	}
+	segment := &TCPSegment{SourcePort: 1024, DestinationPort: 80, SYN: true, OptionData: options}
+	data, _ := ToBytes(segment, SerializeOptions{FixLengths: true})
+	segment = new(TCPSegment)
+	segment.FromBytes(data)
+
+	expected := []TCPOption{
+		TCPOptionMSS{MSS: 1460},
+		TCPOptionSACKPermitted{},
+		TCPOptionTimestamps{TSval: 1},
+		TCPOptionWindowScale{Shift: 7},
+		TCPOptionFastOpen{Cookie: []byte{}},
+		TCPOptionMPTCP{Subtype: 0, Data: []byte{0x00, 0x81}},
+		TCPOptionUnknown{Kind: 0xFD, Data: []byte{0xAB}},
+	}
+	if len(segment.Options) != len(expected) {
+		t.Fatalf("Unexpected options: expected %v, got %v.", expected, segment.Options)
+	}
+	for i, option := range segment.Options {
+		if option.OptionKind() != expected[i].OptionKind() {
+			t.Errorf("Unexpected option %v: expected %+v, got %+v.", i, expected[i], option)
+		}
+	}
+
+	if segment.Option(TCPOPT_MSS).(TCPOptionMSS).MSS != 1460 || segment.Option(TCPOPT_WINDOW_SCALE).(TCPOptionWindowScale).Shift != 7 {
+		t.Errorf("Unexpected options: %+v", segment.Options)
+	}
+	if mptcp := segment.Option(TCPOPT_MPTCP).(TCPOptionMPTCP); bytes.Compare(mptcp.Data, []byte{0x00, 0x81}) != 0 {
+		t.Errorf("Unexpected MPTCP option: %+v", mptcp)
+	}
+}
+
+func TestTCPOptionsSACK(t *testing.T) {
+	// Two SACK blocks.
+	options := parseTCPOptions([]byte{0x01, 0x01, 0x05, 0x12, 0, 0, 0, 10, 0, 0, 0, 20, 0, 0, 0, 30, 0, 0, 0, 40})
+	sack, ok := options[0].(TCPOptionSACK)
+	if len(options) != 1 || !ok || len(sack.Blocks) != 2 || sack.Blocks[1] != (TCPSACKBlock{LeftEdge: 30, RightEdge: 40}) {
+		t.Errorf("Unexpected options: %+v", options)
+	}
+
+	// An option with the wrong length for its kind is unknown.
+	options = parseTCPOptions([]byte{0x02, 0x03, 0x05})
+	if len(options) != 1 || options[0].(TCPOptionUnknown).Kind != TCPOPT_MSS {
+		t.Errorf("Unexpected options: %+v", options)
+	}
+
+	// Decoding stops at the end of the list, or at an option that runs off the end.
+	options = parseTCPOptions([]byte{0x04, 0x02, 0x00, 0x02, 0x04, 0x05, 0xB4})
+	if len(options) != 1 {
+		t.Errorf("Unexpected options: %+v", options)
+	}
+	options = parseTCPOptions([]byte{0x04, 0x02, 0x08, 0x0A, 0x00})
+	if len(options) != 1 {
+		t.Errorf("Unexpected options: %+v", options)
+	}
+}
+
+func TestTCPOptionsEdited(t *testing.T) {
+	// Unchanged options are written back exactly as they were read, NOPs included.
+	pkt := new(TCPSegment)
+	pkt.FromBytes(ngTestFrame[34:])
+	data, err := ToBytes(pkt, SerializeOptions{})
+	if err != nil || bytes.Compare(data, ngTestFrame[34:]) != 0 {
+		t.Errorf("Unexpected segment: %v, %v", data, err)
+	}
+
+	// Changed options replace the option data.
+	pkt.Options = []TCPOption{
+		TCPOptionTimestamps{TSval: 1, TSecr: 2},
+		TCPOptionWindowScale{Shift: 7},
+		TCPOptionMPTCP{Subtype: 2, Data: []byte{0x01, 0x05}},
+	}
+	data, err = ToBytes(pkt, SerializeOptions{FixLengths: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	segment := new(TCPSegment)
+	segment.FromBytes(data)
+	if !tcpOptionsEqual(segment.Options, []TCPOption{
+		TCPOptionTimestamps{TSval: 1, TSecr: 2},
+		TCPOptionWindowScale{Shift: 7},
+		TCPOptionMPTCP{Subtype: 2, Data: []byte{0x21, 0x05}},
+	}) {
+		t.Errorf("Unexpected options: %+v", segment.Options)
+	}
+	if bytes.Compare(segment.TransportData(), pkt.TransportData()) != 0 {
+		t.Errorf("Unexpected payload: %v", segment.TransportData())
+	}
+
+	// An empty list removes them all.
+	pkt.Options = []TCPOption{}
+	data, _ = ToBytes(pkt, SerializeOptions{FixLengths: true})
+	segment = new(TCPSegment)
+	segment.FromBytes(data)
+	if segment.HeaderSize != 5 || len(segment.Options) != 0 {
+		t.Errorf("Unexpected segment: %+v", segment)
+	}
+
+	// The header size follows the options even without FixLengths, and NOPs are single bytes.
+	pkt.Options = []TCPOption{TCPOptionUnknown{Kind: TCPOPT_NOP}, TCPOptionMSS{MSS: 1460}}
+	data, err = ToBytes(pkt, SerializeOptions{})
+	if err != nil || pkt.HeaderSize != 7 || bytes.Compare(pkt.OptionData, []byte{0x01, 0x02, 0x04, 0x05, 0xB4, 0, 0, 0}) != 0 {
+		t.Errorf("Unexpected segment: %+v, %v", pkt, err)
+	}
+	segment = new(TCPSegment)
+	segment.FromBytes(data)
+	if mss, ok := segment.Option(TCPOPT_MSS).(TCPOptionMSS); !ok || mss.MSS != 1460 || len(segment.TransportData()) != len(pkt.TransportData()) {
+		t.Errorf("Unexpected segment: %+v", segment)
+	}
+
+	// Options that don't fit are rejected.
+	invalid := [][]TCPOption{
+		{TCPOptionUnknown{Kind: 0xFD, Data: make([]byte, 254)}},
+		{TCPOptionUnknown{Kind: 0xFD, Data: make([]byte, 40)}},
+		{TCPOptionUnknown{Kind: TCPOPT_NOP, Data: []byte{1}}},
+	}
+	for i, options := range invalid {
+		pkt.Options = options
+		if _, err = ToBytes(pkt, SerializeOptions{}); err != InvalidField {
+			t.Errorf("Unexpected error for options %v: expected %v, got %v", i, InvalidField, err)
+		}
+	}
+}
@@ -44,3 +44,61 @@ func getInt32(buf []byte, flipped bool) int32 {
 
 	return num
 }
+
+// getUint64 takes an eight-element byte slice and returns the uint64 contained within it. If flipped
+// is set, assumes the byte order is reversed.
+func getUint64(buf []byte, flipped bool) uint64 {
+	num := uint64(0)
+
+	for i := 0; i < 8; i++ {
+		index := i
+		if flipped {
+			index = 7 - i
+		}
+
+		num = (num << 8) + uint64(buf[index])
+	}
+
+	return num
+}
+
+// putUint16 writes a uint16 into a two-element byte slice. If flipped is set, the byte order is
+// reversed.
+func putUint16(buf []byte, num uint16, flipped bool) {
+	first, second := 0, 1
+
+	if flipped {
+		first, second = 1, 0
+	}
+
+	buf[first] = byte(num >> 8)
+	buf[second] = byte(num)
+}
+
+// putUint32 writes a uint32 into a four-element byte slice. If flipped is set, the byte order is
+// reversed.
+func putUint32(buf []byte, num uint32, flipped bool) {
+	first, second, third, fourth := 0, 1, 2, 3
+
+	if flipped {
+		first, second, third, fourth = 3, 2, 1, 0
+	}
+
+	buf[first] = byte(num >> 24)
+	buf[second] = byte(num >> 16)
+	buf[third] = byte(num >> 8)
+	buf[fourth] = byte(num)
+}
+
+// putUint64 writes a uint64 into an eight-element byte slice. If flipped is set, the byte order is
+// reversed.
+func putUint64(buf []byte, num uint64, flipped bool) {
+	for i := 0; i < 8; i++ {
+		index := 7 - i
+		if flipped {
+			index = i
+		}
+
+		buf[index] = byte(num >> (8 * uint(i)))
+	}
+}
@@ -1,6 +1,9 @@
 package gopcap
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestGetUint16(t *testing.T) {
 	// Prepare some test byte arrays.
@@ -103,3 +106,63 @@ func TestGetInt32(t *testing.T) {
 		}
 	}
 }
+
+func TestGetUint64(t *testing.T) {
+	// Prepare some test byte arrays.
+	in := [][]byte{
+		[]byte{0x99, 0x12, 0x66, 0x00, 0xff, 0x01, 0x08, 0xdd},
+		[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+	}
+
+	out_not_flipped := []uint64{
+		uint64(11029990591848057053),
+		uint64(1),
+	}
+
+	out_flipped := []uint64{
+		uint64(15926982276930736793),
+		uint64(72057594037927936),
+	}
+
+	for i, input := range in {
+		out1 := getUint64(input, false)
+		out2 := getUint64(input, true)
+
+		if out1 != out_not_flipped[i] {
+			t.Errorf("Incorrect output: expected %v, got %v.", out_not_flipped[i], out1)
+		}
+
+		if out2 != out_flipped[i] {
+			t.Errorf("Incorrect output: expected %v, got %v.", out_flipped[i], out2)
+		}
+	}
+}
+
+func TestPutUints(t *testing.T) {
+	// Writing a value and reading it back should always give the same value.
+	for _, flipped := range []bool{false, true} {
+		buf := make([]byte, 8)
+
+		putUint16(buf, uint16(39186), flipped)
+		if out := getUint16(buf, flipped); out != uint16(39186) {
+			t.Errorf("Incorrect output: expected %v, got %v.", 39186, out)
+		}
+
+		putUint32(buf, uint32(2568119808), flipped)
+		if out := getUint32(buf, flipped); out != uint32(2568119808) {
+			t.Errorf("Incorrect output: expected %v, got %v.", 2568119808, out)
+		}
+
+		putUint64(buf, uint64(11029990591848057053), flipped)
+		if out := getUint64(buf, flipped); out != uint64(11029990591848057053) {
+			t.Errorf("Incorrect output: expected %v, got %v.", uint64(11029990591848057053), out)
+		}
+	}
+
+	// Check the byte order directly too.
+	buf := make([]byte, 4)
+	putUint32(buf, 0xa1b2c3d4, true)
+	if bytes.Compare(buf, []byte{0xd4, 0xc3, 0xb2, 0xa1}) != 0 {
+		t.Errorf("Incorrect output: expected %v, got %v.", []byte{0xd4, 0xc3, 0xb2, 0xa1}, buf)
+	}
+}
@@ -0,0 +1,90 @@
+package gopcap
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors
+var UnsupportedResolution error = errors.New("Unsupported timestamp resolution.")
+
+// Writer writes packets to a .pcap file. The file header is written when the Writer is created,
+// and packet records are then written one at a time by calling WritePacket.
+type Writer struct {
+	dst          io.Writer
+	flipped      bool
+	resolution   time.Duration
+	tzCorrection int32
+}
+
+// NewWriter creates a Writer and writes the pcap file header to dst. The header is taken from the
+// version, TZCorrection, SigFigs, MaxLen, LinkType and TSResolution fields of the PcapFile; any
+// Packets it contains are ignored. A zero version is written as 2.4, and a zero TSResolution as
+// time.Microsecond. If flipped is set, the file is written in reversed (little-endian) byte order,
+// as most capture tools do.
+func NewWriter(dst io.Writer, header PcapFile, flipped bool) (*Writer, error) {
+	w := &Writer{dst: dst, flipped: flipped, resolution: header.TSResolution, tzCorrection: header.TZCorrection}
+
+	// Pick the magic number that matches the timestamp resolution.
+	var magic uint32
+	switch w.resolution {
+	case 0, time.Microsecond:
+		w.resolution = time.Microsecond
+		magic = 0xa1b2c3d4
+	case time.Nanosecond:
+		magic = 0xa1b23c4d
+	default:
+		return w, UnsupportedResolution
+	}
+
+	if header.MajorVersion == 0 && header.MinorVersion == 0 {
+		header.MajorVersion = 2
+		header.MinorVersion = 4
+	}
+
+	buffer := make([]byte, 24)
+	putUint32(buffer[0:4], magic, flipped)
+	putUint16(buffer[4:6], header.MajorVersion, flipped)
+	putUint16(buffer[6:8], header.MinorVersion, flipped)
+	putUint32(buffer[8:12], uint32(header.TZCorrection), flipped)
+	putUint32(buffer[12:16], header.SigFigs, flipped)
+	putUint32(buffer[16:20], header.MaxLen, flipped)
+	putUint32(buffer[20:24], uint32(header.LinkType), flipped)
+
+	_, err := w.dst.Write(buffer)
+	return w, err
+}
+
+// WritePacket writes a single packet record. The timestamp and original length are taken from the
+// Packet, and data holds the captured bytes of the packet. If the Packet's Time is set, it is used
+// in preference to Timestamp, and adjusted for the file's TZCorrection: a packet that was read has
+// Time set, so an edited Timestamp is only written if Time is cleared. The included length is
+// always the length of data: if the Packet's ActualLen is smaller than that, the length of data is
+// used instead.
+func (w *Writer) WritePacket(pkt Packet, data []byte) error {
+	ts := pkt.Timestamp
+	if !pkt.Time.IsZero() {
+		ts = fileTimestamp(pkt.Time, w.tzCorrection)
+	}
+
+	includedLen := uint32(len(data))
+	actualLen := pkt.ActualLen
+	if actualLen < includedLen {
+		actualLen = includedLen
+	}
+
+	buffer := make([]byte, 16)
+	putUint32(buffer[0:4], uint32(ts/time.Second), w.flipped)
+	putUint32(buffer[4:8], uint32((ts%time.Second)/w.resolution), w.flipped)
+	putUint32(buffer[8:12], includedLen, w.flipped)
+	putUint32(buffer[12:16], actualLen, w.flipped)
+
+	_, err := w.dst.Write(buffer)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.dst.Write(data)
+	return err
+}
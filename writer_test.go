@@ -0,0 +1,193 @@
+package gopcap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriterHeader(t *testing.T) {
+	header := PcapFile{TZCorrection: -3600, MaxLen: 65535, LinkType: ETHERNET}
+	in := []bool{false, true, false, true}
+	resolutions := []time.Duration{time.Microsecond, time.Microsecond, time.Nanosecond, time.Nanosecond}
+	out := [][]byte{
+		[]byte{0xa1, 0xb2, 0xc3, 0xd4, 0x00, 0x02, 0x00, 0x04, 0xff, 0xff, 0xf1, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, 0xf0, 0xf1, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+		[]byte{0xa1, 0xb2, 0x3c, 0x4d, 0x00, 0x02, 0x00, 0x04, 0xff, 0xff, 0xf1, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01},
+		[]byte{0x4d, 0x3c, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00, 0xf0, 0xf1, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+	}
+
+	for i, flipped := range in {
+		buf := new(bytes.Buffer)
+		header.TSResolution = resolutions[i]
+		_, err := NewWriter(buf, header, flipped)
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if bytes.Compare(buf.Bytes(), out[i]) != 0 {
+			t.Errorf("Unexpected header: expected %v, got %v.", out[i], buf.Bytes())
+		}
+	}
+}
+
+func TestWriterBadResolution(t *testing.T) {
+	_, err := NewWriter(new(bytes.Buffer), PcapFile{TSResolution: time.Millisecond}, false)
+
+	if err != UnsupportedResolution {
+		t.Errorf("Unexpected error: expected %v, got %v", UnsupportedResolution, err)
+	}
+}
+
+// Writing packets and reading them back should give the same packets, whatever the byte order
+// and resolution.
+func TestWriterRoundTrip(t *testing.T) {
+	pkt := Packet{
+		Timestamp: 321259*time.Hour + 31*time.Minute + 6*time.Second + 654692123*time.Nanosecond,
+		ActualLen: 200,
+	}
+	resolutions := []time.Duration{time.Microsecond, time.Nanosecond}
+
+	for _, flipped := range []bool{false, true} {
+		for _, resolution := range resolutions {
+			buf := new(bytes.Buffer)
+			w, err := NewWriter(buf, PcapFile{MaxLen: 65535, LinkType: ETHERNET, TSResolution: resolution}, flipped)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			err = w.WritePacket(pkt, ngTestFrame)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			r, err := NewReader(buf)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if r.TSResolution != resolution {
+				t.Errorf("Unexpected resolution: expected %v, got %v.", resolution, r.TSResolution)
+			}
+
+			out, err := r.Next()
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if out.Timestamp != pkt.Timestamp.Truncate(resolution) {
+				t.Errorf("Unexpected TS: expected %v, got %v.", pkt.Timestamp.Truncate(resolution), out.Timestamp)
+			}
+			if out.IncludedLen != uint32(len(ngTestFrame)) {
+				t.Errorf("Unexpected included length: expected %v, got %v.", len(ngTestFrame), out.IncludedLen)
+			}
+			if out.ActualLen != 200 {
+				t.Errorf("Unexpected actual length: expected %v, got %v.", 200, out.ActualLen)
+			}
+			if _, ok := out.Data.(*EthernetFrame); !ok {
+				t.Errorf("Unexpected link layer: %v", out.Data)
+			}
+
+			_, err = r.Next()
+			if err != io.EOF {
+				t.Errorf("Unexpected error: expected %v, got %v", io.EOF, err)
+			}
+		}
+	}
+}
+
+// Copying the test file through a Writer should reproduce it exactly.
+func TestWriterCopy(t *testing.T) {
+	original, err := os.ReadFile("SkypeIRC.cap")
+	if err != nil {
+		t.Fatal("Missing pcap file.")
+	}
+
+	r, err := NewReader(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, r.header(), r.flipped)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Each record is 16 bytes of header followed by the packet data.
+	offset := 24
+	for {
+		pkt, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		offset += 16
+		w.WritePacket(pkt, original[offset:offset+int(pkt.IncludedLen)])
+		offset += int(pkt.IncludedLen)
+	}
+
+	if bytes.Compare(buf.Bytes(), original) != 0 {
+		t.Errorf("Copied file differs from the original.")
+	}
+}
+
+// Absolute times should be written as local time when the file has a TZCorrection, and read back
+// as the same absolute time. A TZCorrection of -18000 is GMT+5, so local time is five hours ahead.
+func TestWriterTZCorrection(t *testing.T) {
+	when := time.Date(2014, 11, 10, 12, 0, 0, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, PcapFile{TZCorrection: -18000, LinkType: ETHERNET}, false)
+
+	err := w.WritePacket(Packet{Time: when, Timestamp: time.Hour}, ngTestFrame)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r, _ := NewReader(buf)
+	pkt, err := r.Next()
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expectedTS := time.Duration(when.UnixNano()) + 5*time.Hour
+	if pkt.Timestamp != expectedTS {
+		t.Errorf("Unexpected TS: expected %v, got %v.", expectedTS, pkt.Timestamp)
+	}
+	if !pkt.Time.Equal(when) {
+		t.Errorf("Unexpected time: expected %v, got %v.", when, pkt.Time)
+	}
+}
+
+// A packet that was read has Time set, which takes precedence over Timestamp, so a new Timestamp is
+// only written once Time is cleared.
+func TestWriterTimePrecedence(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, _ := NewWriter(buf, PcapFile{LinkType: ETHERNET}, false)
+	w.WritePacket(Packet{Timestamp: time.Hour}, ngTestFrame)
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()))
+	pkt, err := r.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []time.Duration{time.Hour, 3 * time.Hour, 2 * time.Hour}
+	pkt.Timestamp = 2 * time.Hour
+	buf = new(bytes.Buffer)
+	w, _ = NewWriter(buf, PcapFile{LinkType: ETHERNET}, false)
+	w.WritePacket(pkt, ngTestFrame)
+	pkt.Time = time.Unix(0, 0).Add(3 * time.Hour)
+	w.WritePacket(pkt, ngTestFrame)
+	pkt.Time = time.Time{}
+	w.WritePacket(pkt, ngTestFrame)
+
+	r, _ = NewReader(buf)
+	for i, ts := range expected {
+		pkt, err = r.Next()
+		if err != nil || pkt.Timestamp != ts {
+			t.Errorf("Unexpected TS for packet %v: expected %v, got %v (%v).", i, ts, pkt.Timestamp, err)
+		}
+	}
+}